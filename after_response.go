@@ -0,0 +1,53 @@
+package web
+
+import (
+	"context"
+	"sync"
+)
+
+type afterResponseKey struct{}
+
+// afterResponseFuncs are the funcs run after the response of a page or an event is written
+type afterResponseFuncs struct {
+	mu  sync.Mutex
+	fs  []func()
+	ran bool
+}
+
+// AfterResponse runs f after the response of the page or the event rendered with ctx is written, or failed,
+// e.g. to release the resources held for the request. The funcs run in reverse order.
+// It returns false without running f if ctx is not rendered by a PageBuilder.
+func AfterResponse(ctx context.Context, f func()) bool {
+	evCtx, ok := GetEventContext(ctx)
+	if !ok {
+		return false
+	}
+	fs, ok := evCtx.ContextValue(afterResponseKey{}).(*afterResponseFuncs)
+	if !ok {
+		return false
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.ran {
+		return false
+	}
+	fs.fs = append(fs.fs, f)
+	return true
+}
+
+func withAfterResponse(ctx *EventContext) *afterResponseFuncs {
+	fs := &afterResponseFuncs{}
+	ctx.WithContextValue(afterResponseKey{}, fs)
+	return fs
+}
+
+func (s *afterResponseFuncs) run() {
+	s.mu.Lock()
+	fs := s.fs
+	s.fs, s.ran = nil, true
+	s.mu.Unlock()
+
+	for i := len(fs) - 1; i >= 0; i-- {
+		fs[i]()
+	}
+}
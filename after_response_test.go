@@ -0,0 +1,61 @@
+package web_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	h "github.com/theplant/htmlgo"
+
+	. "github.com/qor5/web/v3"
+)
+
+func TestAfterResponse(t *testing.T) {
+	var calls []string
+	var w *httptest.ResponseRecorder
+	releasing := func(name string) h.HTMLComponent {
+		return h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+			AfterResponse(ctx, func() {
+				calls = append(calls, name+":"+w.Body.String()[:1])
+			})
+			return h.Div().Text(name).MarshalHTML(ctx)
+		})
+	}
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		pr.Body = h.Components(releasing("a"), releasing("b"))
+		return
+	}).EventFunc("update", func(ctx *EventContext) (r EventResponse, err error) {
+		r.Body = releasing("c")
+		return
+	}).EventFunc("fail", func(ctx *EventContext) (r EventResponse, err error) {
+		AfterResponse(ctx.R.Context(), func() { calls = append(calls, "failed") })
+		panic("failed")
+	})
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !reflect.DeepEqual(calls, []string{"b:<", "a:<"}) {
+		t.Error("the funcs are not run in reverse order after the page is written", calls)
+	}
+
+	calls = nil
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/?__execute_event__=update", nil))
+	if !reflect.DeepEqual(calls, []string{"c:{"}) {
+		t.Error("the funcs are not run after the event response is written", calls)
+	}
+
+	calls = nil
+	func() {
+		defer func() { recover() }()
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/?__execute_event__=fail", nil))
+	}()
+	if !reflect.DeepEqual(calls, []string{"failed"}) {
+		t.Error("the funcs are not run after the event failed", calls)
+	}
+
+	if AfterResponse(context.Background(), func() {}) {
+		t.Error("the funcs are registered without a page")
+	}
+}
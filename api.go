@@ -2,10 +2,12 @@ package web
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/form/v4"
 	"github.com/sunfmin/reflectutils"
@@ -17,26 +19,122 @@ type PageResponse struct {
 	Body      h.HTMLComponent
 }
 
+type PortalUpdateMode string
+
+const (
+	// PortalUpdateModeReplace replaces the whole body of the portal, or only the item of the key if it is given.
+	// It is the default of the empty mode. The replace of a key which is not in the portal is ignored,
+	// since the item may have been removed by an earlier update, use PortalUpdateModeAppend to add or replace it.
+	PortalUpdateModeReplace PortalUpdateMode = "replace"
+	// PortalUpdateModeReplaceOuter replaces the portal element itself with the body instead of its children,
+	// like the outerHTML, so the body is not wrapped by the element of the portal, e.g. a re-rendered web.Portal with new props.
+	// The portal keeps its name, so the later updates and reloads of the name still apply to it.
+	PortalUpdateModeReplaceOuter PortalUpdateMode = "replace-outer"
+	// PortalUpdateModeAppend adds the body as an item after the existing children, e.g. the next page of an infinite list
+	PortalUpdateModeAppend PortalUpdateMode = "append"
+	// PortalUpdateModePrepend adds the body as an item before the existing children, e.g. the new message of a chat log
+	PortalUpdateModePrepend PortalUpdateMode = "prepend"
+	// PortalUpdateModeRemove removes the item of the key, or the whole body of the portal if no key is given
+	PortalUpdateModeRemove PortalUpdateMode = "remove"
+)
+
 type PortalUpdate struct {
-	Name string          `json:"name,omitempty"`
-	Body h.HTMLComponent `json:"body,omitempty"`
+	Name string           `json:"name,omitempty"`
+	Body h.HTMLComponent  `json:"body,omitempty"`
+	Mode PortalUpdateMode `json:"mode,omitempty"`
+	// Key identifies the item appended or prepended to the portal, so that it can be replaced or removed later,
+	// the item of an existing key is replaced in place instead of being added again.
+	// The existing children are not re-rendered by the updates of the items, so their local states are kept.
+	Key string `json:"key,omitempty"`
+	// AfterLoaded runs after the body is rendered, e.g. web.PortalAfterLoaded().Focus("input")
+	AfterLoaded *PortalAfterLoadedBuilder `json:"afterLoaded,omitempty"`
 }
 
 // @snippet_begin(EventResponseDefinition)
 type EventResponse struct {
-	PageTitle     string           `json:"pageTitle,omitempty"`
-	Body          h.HTMLComponent  `json:"body,omitempty"`
-	Reload        bool             `json:"reload,omitempty"`
-	PushState     *LocationBuilder `json:"pushState"`             // This we don't omitempty, So that {} can be kept when use url.Values{}
-	RedirectURL   string           `json:"redirectURL,omitempty"` // change window url without push state
-	ReloadPortals []string         `json:"reloadPortals,omitempty"`
-	UpdatePortals []*PortalUpdate  `json:"updatePortals,omitempty"`
-	Data          interface{}      `json:"data,omitempty"`      // used for return collection data like TagsInput data source
-	RunScript     string           `json:"runScript,omitempty"` // used with InitContextVars to set values for example vars.show to used by v-model
+	PageTitle        string           `json:"pageTitle,omitempty"`
+	Body             h.HTMLComponent  `json:"body,omitempty"`
+	Reload           bool             `json:"reload,omitempty"`
+	PushState        *LocationBuilder `json:"pushState"`             // This we don't omitempty, So that {} can be kept when use url.Values{}
+	RedirectURL      string           `json:"redirectURL,omitempty"` // change window url without push state
+	ReloadPortals    []string         `json:"reloadPortals,omitempty"`
+	UpdatePortals    []*PortalUpdate  `json:"updatePortals,omitempty"`
+	Data             interface{}      `json:"data,omitempty"`             // used for return collection data like TagsInput data source
+	RunScript        string           `json:"runScript,omitempty"`        // used with InitContextVars to set values for example vars.show to used by v-model
+	NotModified      bool             `json:"notModified,omitempty"`      // the portal loaded by the event keeps its body, e.g. the polled data is not changed
+	AutoReload       *AutoReload      `json:"autoReload,omitempty"`       // adjusts the AutoReloadInterval of the portal loaded by the event
+	Cache            *ResponseCache   `json:"cache,omitempty"`            // caches the response of the portal loader in the browser
+	InvalidateCaches []string         `json:"invalidateCaches,omitempty"` // the keys of the cached responses dropped, see InvalidateCaches
+	States           []*StateUpdate   `json:"states,omitempty"`           // the values set to the vars, locals or dash before the RunScript, see SetState
 }
 
 // @snippet_end
 
+// AutoReload adjusts the polling of the portal from the server, e.g. Stop when the job the portal shows is finished
+type AutoReload struct {
+	Interval time.Duration // replaces the interval of the portal if it is positive
+	Stop     bool
+}
+
+func (v *AutoReload) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Interval int64 `json:"interval,omitempty"`
+		Stop     bool  `json:"stop,omitempty"`
+	}{
+		Interval: v.Interval.Milliseconds(),
+		Stop:     v.Stop,
+	})
+}
+
+// ResponseCache makes the browser reuse the response of the portal loader for the same request until the TTL passed,
+// e.g. the body of a drawer which is opened again and again.
+type ResponseCache struct {
+	TTL time.Duration
+	// Key names the cached response for InvalidateCaches, the response is only invalidated by the TTL if it is empty
+	Key string
+	// VaryByQuery are the queries of the page the response depends on, the cached response is not reused if they are changed
+	VaryByQuery []string
+}
+
+func (v *ResponseCache) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TTL         int64    `json:"ttl"`
+		Key         string   `json:"key,omitempty"`
+		VaryByQuery []string `json:"varyByQuery,omitempty"`
+	}{
+		TTL:         v.TTL.Milliseconds(),
+		Key:         v.Key,
+		VaryByQuery: v.VaryByQuery,
+	})
+}
+
+// InvalidateCaches drops the cached responses of the keys in the browser, e.g. after the data shown by them is updated
+func InvalidateCaches(er *EventResponse, keys ...string) {
+	er.InvalidateCaches = append(er.InvalidateCaches, keys...)
+}
+
+type StateTarget string
+
+const (
+	StateVars   StateTarget = "vars"
+	StateLocals StateTarget = "locals"
+	StateDash   StateTarget = "dash"
+)
+
+// StateUpdate sets the value to the path of the state, e.g. "user.name" or "items[0]" like lodash set,
+// the empty path merges the value which must be an object into the state
+type StateUpdate struct {
+	Target StateTarget `json:"target"`
+	Path   string      `json:"path,omitempty"`
+	Value  any         `json:"value"`
+}
+
+// SetState sets the value encoded as JSON to the path of the vars, locals or dash of the event in the browser,
+// instead of building the script to assign it
+func (r *EventResponse) SetState(target StateTarget, path string, value any) {
+	r.States = append(r.States, &StateUpdate{Target: target, Path: path, Value: value})
+}
+
 // @snippet_begin(PageFuncAndEventFuncDefinition)
 type (
 	PageFunc  func(ctx *EventContext) (r PageResponse, err error)
@@ -143,7 +241,10 @@ func (ctx *EventContext) UnmarshalForm(v interface{}) (err error) {
 
 type contextKey int
 
-const eventKey contextKey = iota
+const (
+	eventKey contextKey = iota
+	portalLoaderInlinerKey
+)
 
 func (e *EventContext) withSelf() (r *EventContext) {
 	e.R = e.R.WithContext(context.WithValue(e.R.Context(), eventKey, e))
@@ -0,0 +1,212 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	h "github.com/theplant/htmlgo"
+)
+
+// BatchEventFuncID is the event func of the pages with EnableBatch which executes the events batched by the browser,
+// e.g. the loaders of the portals loaded in the same tick are posted in one request.
+//
+// The batched events are posted with the query of __execute_event__ set to BatchEventFuncID,
+// so the http middlewares which authorize the requests by the event func of the query don't see them,
+// authorize the event funcs by PageBuilder.WrapEventFunc instead, which wraps each of the batched ones as well.
+const BatchEventFuncID = "__batch__"
+
+const (
+	DefaultBatchConcurrency = 4
+	// DefaultBatchMaxEvents is the limit of the events of a batch, the browser is told it by the page
+	DefaultBatchMaxEvents = 10
+)
+
+const batchFieldName = "__batch__"
+
+// batchEvent is an event posted by the browser, the url is the one it would be posted to alone
+type batchEvent struct {
+	URL    string     `json:"url"`
+	Fields url.Values `json:"fields"`
+}
+
+// batchEventResult is the response of a batched event, or the error if it failed without failing the others
+type batchEventResult struct {
+	Response *EventResponse `json:"response,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// EnableBatch makes the browser post the portal loaders of the page loaded in the same tick in one request,
+// it registers the BatchEventFuncID event func and tells the browser the limit of the events of a batch in the head of the page
+func (p *PageBuilder) EnableBatch() (r *PageBuilder) {
+	p.batchEnabled = true
+	p.RegisterEventFunc(BatchEventFuncID, p.batch)
+	return p
+}
+
+// BatchConcurrency limits the batched events executed at the same time, defaults to DefaultBatchConcurrency
+func (p *PageBuilder) BatchConcurrency(n int) (r *PageBuilder) {
+	p.batchConcurrency = n
+	return p
+}
+
+// BatchMaxEvents limits the events of a batch, the larger batches are rejected, defaults to DefaultBatchMaxEvents
+func (p *PageBuilder) BatchMaxEvents(n int) (r *PageBuilder) {
+	p.batchMaxEvents = n
+	return p
+}
+
+func (p *PageBuilder) maxBatchEvents() int {
+	if p.batchMaxEvents <= 0 {
+		return DefaultBatchMaxEvents
+	}
+	return p.batchMaxEvents
+}
+
+// injectBatchScript tells the browser the path of the page accepts the batches and the limit of their events,
+// it is in the head of the page and before the run script of the reloads, which are the pages pushed without loading the document
+func (p *PageBuilder) injectBatchScript(ctx *EventContext) {
+	InjectScript(ctx.R.Context(), "web:batch", fmt.Sprintf(
+		"(window.__goplaid = window.__goplaid || {}).batches = Object.assign(window.__goplaid.batches || {}, {%s: %d})",
+		h.JSONString(ctx.R.URL.Path), p.maxBatchEvents(),
+	))
+}
+
+// onlyDefaultEventFuncs reports if the page has only the event funcs registered by default, reload and batch,
+// e.g. the ones registered in the render are gone because the server restarted
+func (p *PageBuilder) onlyDefaultEventFuncs() bool {
+	n := 1
+	if p.batchEnabled {
+		n++
+	}
+	return len(p.eventFuncs) <= n
+}
+
+// batch executes the batched events, each one with its own EventContext, and responds their results as the data in order
+func (p *PageBuilder) batch(ctx *EventContext) (r EventResponse, err error) {
+	var events []batchEvent
+	if err = json.Unmarshal([]byte(ctx.R.FormValue(batchFieldName)), &events); err != nil {
+		return r, fmt.Errorf("invalid batch events: %w", err)
+	}
+	maxEvents := p.maxBatchEvents()
+	if len(events) > maxEvents {
+		return r, fmt.Errorf("too many batch events: %d, at most %d", len(events), maxEvents)
+	}
+
+	// the event funcs registered in the render are gone if the server restarted, see executeEvent
+	if p.onlyDefaultEventFuncs() {
+		for _, ev := range events {
+			if u, err := url.Parse(ev.URL); err == nil && p.lookupEventFunc(u.Query().Get(EventFuncIDName)) == nil {
+				log.Println("Re-render because event funcs gone, might server restarted")
+				p.render(ctx, true)
+				break
+			}
+		}
+	}
+
+	concurrency := p.batchConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	results := make([]*batchEventResult, len(events))
+	writers := make([]*capturedResponseWriter, len(events))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, ev := range events {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			writers[i] = newCapturedResponseWriter()
+			results[i] = p.runBatchEvent(ctx, ev, writers[i])
+		}()
+	}
+	wg.Wait()
+
+	// the cookies are the only headers of the batched events kept, e.g. the sessions refreshed
+	for _, w := range writers {
+		w.copyCookiesTo(ctx.W)
+	}
+	r.Data = results
+	return
+}
+
+// runBatchEvent executes the event like executeEvent, the panic or the error of it is logged and responded as the result
+func (p *PageBuilder) runBatchEvent(ctx *EventContext, ev batchEvent, w http.ResponseWriter) (result *batchEventResult) {
+	var eventFuncID string
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("batch event %s panicked: %v\n", eventFuncID, rec)
+			result = &batchEventResult{Error: fmt.Sprintf("failed to execute event %s", eventFuncID)}
+		}
+	}()
+
+	u, err := ctx.R.URL.Parse(ev.URL)
+	if err != nil {
+		return &batchEventResult{Error: fmt.Sprintf("invalid url %q", ev.URL)}
+	}
+	if u.Scheme != "" || u.Host != "" || u.Path != ctx.R.URL.Path {
+		return &batchEventResult{Error: fmt.Sprintf("url %q is not the page of the batch", ev.URL)}
+	}
+	eventFuncID = u.Query().Get(EventFuncIDName)
+	ef := p.lookupEventFunc(eventFuncID)
+	if ef == nil || eventFuncID == BatchEventFuncID {
+		return &batchEventResult{Error: fmt.Sprintf("event %s not found", eventFuncID)}
+	}
+
+	req, err := eventRequest(ctx.R, u, ev.Fields)
+	if err != nil {
+		panic(err)
+	}
+	evCtx := &EventContext{
+		R:        req,
+		W:        w,
+		Injector: &PageInjector{},
+	}
+	evCtx.withSelf()
+	scripts := withEventScripts(evCtx)
+	er, err := ef(evCtx)
+	if err != nil {
+		panic(err)
+	}
+	p.renderEventResponse(evCtx, &er)
+	scripts.prependTo(&er)
+	return &batchEventResult{Response: &er}
+}
+
+// capturedResponseWriter keeps the headers of an event run inside another request and drops the body written directly,
+// e.g. a batched event or an inlined portal loader
+type capturedResponseWriter struct {
+	header  http.Header
+	written bool
+}
+
+func newCapturedResponseWriter() *capturedResponseWriter {
+	return &capturedResponseWriter{header: http.Header{}}
+}
+
+func (w *capturedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *capturedResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return len(b), nil
+}
+
+func (w *capturedResponseWriter) WriteHeader(statusCode int) {
+	w.written = true
+}
+
+// copyCookiesTo adds the cookies set by the event to the response of the request running it
+func (w *capturedResponseWriter) copyCookiesTo(dst http.ResponseWriter) {
+	for _, cookie := range w.header.Values("Set-Cookie") {
+		dst.Header().Add("Set-Cookie", cookie)
+	}
+}
@@ -0,0 +1,161 @@
+package web_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	h "github.com/theplant/htmlgo"
+	"github.com/theplant/testingutils"
+
+	. "github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+)
+
+func TestBatchEvents(t *testing.T) {
+	var running, maxRunning int32
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		return
+	}).EventFunc("load", func(ctx *EventContext) (r EventResponse, err error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		http.SetCookie(ctx.W, &http.Cookie{Name: "id" + ctx.R.FormValue("id"), Value: ctx.R.FormValue("name")})
+		r.Body = h.Div().Text(ctx.R.FormValue("id") + ":" + ctx.R.FormValue("name"))
+		return
+	}).EventFunc("fail", func(ctx *EventContext) (r EventResponse, err error) {
+		return r, errors.New("database is down")
+	}).EventFunc("crash", func(ctx *EventContext) (r EventResponse, err error) {
+		panic("crashed")
+	}).EnableBatch().BatchConcurrency(2)
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/dashboard").
+		EventFunc(BatchEventFuncID).
+		AddField("__batch__", `[
+	{"url": "/dashboard?__execute_event__=load&id=1", "fields": {"name": ["a"]}},
+	{"url": "/dashboard?__execute_event__=fail"},
+	{"url": "/dashboard?__execute_event__=load&id=2", "fields": {"name": ["b"]}},
+	{"url": "/dashboard?__execute_event__=crash"},
+	{"url": "/other?__execute_event__=load&id=3"},
+	{"url": "/dashboard?__execute_event__=missing"},
+	{"url": "/dashboard?__execute_event__=load&id=4", "fields": {"name": ["d"]}}
+]`).
+		BuildEventFuncRequest()
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+
+	expected := `{
+	"body": "",
+	"pushState": null,
+	"data": [
+		{"response": {"body": "\n<div>1:a</div>\n", "pushState": null}},
+		{"error": "failed to execute event fail"},
+		{"response": {"body": "\n<div>2:b</div>\n", "pushState": null}},
+		{"error": "failed to execute event crash"},
+		{"error": "url \"/other?__execute_event__=load&id=3\" is not the page of the batch"},
+		{"error": "event missing not found"},
+		{"response": {"body": "\n<div>4:d</div>\n", "pushState": null}}
+	]
+}`
+	if diff := testingutils.PrettyJsonDiff(expected, w.Body.String()); diff != "" {
+		t.Error(diff)
+	}
+	if maxRunning != 2 {
+		t.Errorf("expected at most 2 events running at the same time, got %d", maxRunning)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 3 {
+		t.Errorf("expected the cookies of the batched events, got %v", cookies)
+	}
+}
+
+func TestBatchEventsLimit(t *testing.T) {
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		return
+	}).EventFunc("load", func(ctx *EventContext) (r EventResponse, err error) {
+		t.Error("the events of the rejected batch are executed")
+		return
+	}).EnableBatch().BatchMaxEvents(2)
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/dashboard").
+		EventFunc(BatchEventFuncID).
+		AddField("__batch__", `[
+	{"url": "/dashboard?__execute_event__=load&id=1"},
+	{"url": "/dashboard?__execute_event__=load&id=2"},
+	{"url": "/dashboard?__execute_event__=load&id=3"}
+]`).
+		BuildEventFuncRequest()
+	defer func() {
+		if rec := recover(); rec == nil || !strings.Contains(fmt.Sprint(rec), "too many batch events: 3, at most 2") {
+			t.Errorf("expected the batch to be rejected, got %v", rec)
+		}
+	}()
+	p.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestBatchNotEnabled(t *testing.T) {
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		pr.Body = h.Div().Text("dashboard")
+		return
+	}).EventFunc("load", func(ctx *EventContext) (r EventResponse, err error) {
+		t.Error("the events of the batch are executed")
+		return
+	})
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/dashboard", nil))
+	if strings.Contains(w.Body.String(), "batches") {
+		t.Error("the batch is told to the browser without EnableBatch", w.Body.String())
+	}
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/dashboard").
+		EventFunc(BatchEventFuncID).
+		AddField("__batch__", `[{"url": "/dashboard?__execute_event__=load"}]`).
+		BuildEventFuncRequest()
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the batch not found without EnableBatch, got %d", w.Code)
+	}
+}
+
+func TestBatchScript(t *testing.T) {
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		pr.Body = h.Div().Text("dashboard")
+		return
+	}).EnableBatch().BatchMaxEvents(5)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/dashboard", nil))
+	script := `(window.__goplaid = window.__goplaid || {}).batches = Object.assign(window.__goplaid.batches || {}, {"/dashboard": 5})`
+	body := w.Body.String()
+	if i := strings.Index(body, script); i < 0 || i > strings.Index(body, "</head>") {
+		t.Error("the batch is not told to the browser in the head", body)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/dashboard?__execute_event__=__reload__", nil))
+	var er struct {
+		RunScript string `json:"runScript"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &er); err != nil {
+		t.Fatal(err)
+	}
+	if er.RunScript != script {
+		t.Errorf("the batch is not told to the browser by the reload, got %q", er.RunScript)
+	}
+}
@@ -0,0 +1,143 @@
+import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest'
+import { batchFetch } from '@/batch'
+
+declare let window: any
+
+describe('batch', () => {
+  const post = (fields: Record<string, string> = {}): RequestInit => {
+    const body = new FormData()
+    Object.entries(fields).forEach(([k, v]) => body.set(k, v))
+    return { method: 'POST', body }
+  }
+
+  // the page of /dashboard accepts the batches, see web.PageBuilder.EnableBatch
+  beforeEach(() => {
+    window.__goplaid = { batches: { '/dashboard': 10 } }
+  })
+
+  afterEach(() => {
+    delete window.__goplaid
+  })
+
+  it('batches the events posted in the same tick', async () => {
+    const fetches: { url: string; body: any }[] = []
+    global.fetch = vi.fn().mockImplementation((url, opts) => {
+      fetches.push({ url, body: Object.fromEntries(opts.body) })
+      return Promise.resolve(
+        new Response(
+          JSON.stringify({
+            data: [
+              { response: { body: '<div>a</div>' } },
+              { error: 'failed to execute event b' }
+            ]
+          })
+        )
+      )
+    })
+
+    const a = batchFetch('/dashboard?__execute_event__=a', post({ id: '1' }))
+    const b = batchFetch('/dashboard?__execute_event__=b', post())
+    const [ra, rb] = await Promise.allSettled([a, b])
+
+    expect(fetches).toHaveLength(1)
+    expect(fetches[0].url).toEqual('/dashboard?__execute_event__=__batch__')
+    expect(JSON.parse(fetches[0].body.__batch__)).toEqual([
+      { url: '/dashboard?__execute_event__=a', fields: { id: ['1'] } },
+      { url: '/dashboard?__execute_event__=b', fields: {} }
+    ])
+    expect(ra.status).toEqual('fulfilled')
+    expect(await (ra as PromiseFulfilledResult<Response>).value.json()).toEqual({
+      body: '<div>a</div>'
+    })
+    expect(rb.status).toEqual('rejected')
+    expect((rb as PromiseRejectedResult).reason.message).toEqual('failed to execute event b')
+  })
+
+  it('fetches the events one by one if the batch fails', async () => {
+    const urls: string[] = []
+    global.fetch = vi.fn().mockImplementation((url) => {
+      urls.push(url)
+      if (url.includes('__batch__')) {
+        return Promise.resolve(new Response('404 page not found', { status: 404 }))
+      }
+      return Promise.resolve(new Response(JSON.stringify({ body: url })))
+    })
+
+    const [ra, rb] = await Promise.all([
+      batchFetch('/dashboard?__execute_event__=a', post()),
+      batchFetch('/dashboard?__execute_event__=b', post())
+    ])
+    expect(urls).toEqual([
+      '/dashboard?__execute_event__=__batch__',
+      '/dashboard?__execute_event__=a',
+      '/dashboard?__execute_event__=b'
+    ])
+    expect(await ra.json()).toEqual({ body: '/dashboard?__execute_event__=a' })
+    expect(await rb.json()).toEqual({ body: '/dashboard?__execute_event__=b' })
+  })
+
+  it('fetches the single or unbatchable events directly', async () => {
+    const urls: string[] = []
+    global.fetch = vi.fn().mockImplementation((url) => {
+      urls.push(url)
+      return Promise.resolve(new Response(JSON.stringify({ body: url })))
+    })
+
+    const file = post()
+    ;(file.body as FormData).set('file', new Blob(['x']), 'x.txt')
+    await Promise.all([
+      batchFetch('/dashboard?__execute_event__=a', post()),
+      batchFetch('/other?__execute_event__=b', post()),
+      batchFetch('/dashboard?__execute_event__=c', file),
+      batchFetch(`${window.location.origin}/dashboard?__execute_event__=d`, { method: 'GET' })
+    ])
+    expect(urls.sort()).toEqual(
+      [
+        '/dashboard?__execute_event__=a',
+        '/other?__execute_event__=b',
+        '/dashboard?__execute_event__=c',
+        `${window.location.origin}/dashboard?__execute_event__=d`
+      ].sort()
+    )
+  })
+
+  it('splits the batches by the limit of the page', async () => {
+    window.__goplaid.batches['/dashboard'] = 2
+    const batches: string[][] = []
+    global.fetch = vi.fn().mockImplementation((url, opts) => {
+      if (!url.includes('__batch__')) {
+        batches.push([url])
+        return Promise.resolve(new Response(JSON.stringify({ body: url })))
+      }
+      const events = JSON.parse(opts.body.get('__batch__'))
+      batches.push(events.map((ev: any) => ev.url))
+      return Promise.resolve(
+        new Response(JSON.stringify({ data: events.map((ev: any) => ({ response: { body: ev.url } })) }))
+      )
+    })
+
+    await Promise.all(
+      ['a', 'b', 'c', 'd', 'e'].map((id) => batchFetch(`/dashboard?__execute_event__=${id}`, post()))
+    )
+    expect(batches).toEqual([
+      ['/dashboard?__execute_event__=a', '/dashboard?__execute_event__=b'],
+      ['/dashboard?__execute_event__=c', '/dashboard?__execute_event__=d'],
+      ['/dashboard?__execute_event__=e']
+    ])
+  })
+
+  it('fetches the events directly if the page does not accept the batches', async () => {
+    delete window.__goplaid
+    const urls: string[] = []
+    global.fetch = vi.fn().mockImplementation((url) => {
+      urls.push(url)
+      return Promise.resolve(new Response(JSON.stringify({ body: url })))
+    })
+
+    await Promise.all([
+      batchFetch('/dashboard?__execute_event__=a', post()),
+      batchFetch('/dashboard?__execute_event__=b', post())
+    ])
+    expect(urls).toEqual(['/dashboard?__execute_event__=a', '/dashboard?__execute_event__=b'])
+  })
+})
@@ -1,4 +1,4 @@
-import { describe, it, expect } from 'vitest'
+import { describe, it, expect, vi } from 'vitest'
 import { plaid } from '../builder'
 import { mockFetchWithReturnTemplate, mountTemplate } from './testutils'
 import { flushPromises } from '@vue/test-utils'
@@ -274,6 +274,57 @@ describe('builder', () => {
     expect(lastOpts.body.get('name')).toEqual('felix')
   })
 
+  it('states of the response are set before the runScript', async () => {
+    const template = `
+      <go-plaid-scope :init='{user: {name: "a"}, items: [1, 2]}' v-slot='{ locals }'>
+        <button @click='plaid().vars(vars).locals(locals).eventFunc("update").go()'>update</button>
+        <h1>{{ locals.user.name }} {{ locals.items.join(",") }} {{ vars.count }}</h1>
+      </go-plaid-scope>
+    `
+    const wrapper = mountTemplate(template)
+    await nextTick()
+
+    const form = ref(new FormData())
+    mockFetchWithReturnTemplate(form, () => ({
+      states: [
+        { target: 'locals', path: 'user.name', value: '"b"</script>' },
+        { target: 'locals', path: 'items[1]', value: 3 },
+        { target: 'vars', value: { count: 2 } },
+        { target: 'dash', path: 'ignored', value: true }
+      ],
+      runScript: 'vars.count = vars.count * 10'
+    }))
+    await wrapper.find('button').trigger('click')
+    await flushPromises()
+    expect(wrapper.find('h1').text()).toEqual('"b"</script> 1,3 20')
+  })
+
+  it('runScript patches the compo registered in vars from a nested scope', async () => {
+    // the stateful compos register their locals by the compo id, see stateful.AppendPatchToResponse
+    const template = `
+      <go-plaid-scope :init='{compo: {count: 0}, version: "1"}' :expose-as='["__statefulCompos", "counter:0"]' v-slot='{ locals }'>
+        <h1>{{ locals.compo.count }} {{ locals.version }}</h1>
+        <go-plaid-scope :init='{compo: {count: 10}}' v-slot='{ locals }'>
+          <button @click='plaid().vars(vars).locals(locals).eventFunc("incr").go()'>incr</button>
+          <h2>{{ locals.compo.count }}</h2>
+        </go-plaid-scope>
+      </go-plaid-scope>
+    `
+    const wrapper = mountTemplate(template)
+    await nextTick()
+
+    const form = ref(new FormData())
+    mockFetchWithReturnTemplate(form, () => ({
+      runScript:
+        '((locals) => { if (locals) { plaid().applyJsonPatch(locals.compo, [{"value":1,"op":"replace","path":"/count"}]); locals.version = "2"; } })((vars.__statefulCompos || {})["counter:0"]);\n' +
+        '((locals) => { if (locals) { locals.version = "9"; } })((vars.__statefulCompos || {})["counter:unmounted"])'
+    }))
+    await wrapper.find('button').trigger('click')
+    await flushPromises()
+    expect(wrapper.find('h1').text()).toEqual('1 2')
+    expect(wrapper.find('h2').text()).toEqual('10')
+  })
+
   it('stringifyOptions with encode true', () => {
     const b = plaid()
       .url('/page1?')
@@ -318,4 +369,24 @@ describe('builder', () => {
       )
     ).toEqual(true)
   })
+
+  it('throws the errors only if asked, otherwise alerts them', async () => {
+    const urls: string[] = []
+    global.fetch = vi.fn().mockImplementation((url) => {
+      urls.push(url)
+      return Promise.resolve(new Response('internal error', { status: 500 }))
+    })
+    const alert = vi.spyOn(window, 'alert').mockImplementation(() => {})
+
+    await plaid().eventFunc('load').loadPortalBody(true).go()
+    expect(alert).toHaveBeenCalledWith('Unknown Error')
+    expect(urls[0]).toContain('__load_portal_body__=1')
+
+    alert.mockClear()
+    await expect(
+      plaid().eventFunc('load').loadPortalBody(true).throwErrors(true).go()
+    ).rejects.toThrow('500')
+    expect(alert).not.toHaveBeenCalled()
+    alert.mockRestore()
+  })
 })
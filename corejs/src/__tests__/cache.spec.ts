@@ -0,0 +1,58 @@
+import { describe, it, expect, vi, afterEach } from 'vitest'
+import { invalidateCaches, lookupCache, requestCacheID, storeCache } from '@/cache'
+
+describe('cache', () => {
+  const post = (fields: Record<string, string> = {}): RequestInit => {
+    const body = new FormData()
+    Object.entries(fields).forEach(([k, v]) => body.set(k, v))
+    return { method: 'POST', body }
+  }
+
+  afterEach(() => {
+    vi.useRealTimers()
+    window.history.replaceState({}, '', '/')
+  })
+
+  it('identifies the requests by the urls and the fields', () => {
+    const id = requestCacheID('/page?__execute_event__=a', post({ id: '1' }))
+    expect(id).toEqual(requestCacheID('/page?__execute_event__=a', post({ id: '1' })))
+    expect(id).not.toEqual(requestCacheID('/page?__execute_event__=a', post({ id: '2' })))
+
+    const file = post()
+    ;(file.body as FormData).set('file', new Blob(['x']), 'x.txt')
+    expect(requestCacheID('/page?__execute_event__=a', file)).toEqual('')
+  })
+
+  it('reuses the response until it expires', () => {
+    vi.useFakeTimers()
+    const id = requestCacheID('/page?__execute_event__=ttl', post())
+    storeCache(id, { body: '<div>a</div>', cache: { ttl: 1000 } })
+    expect(lookupCache(id)).toEqual({ body: '<div>a</div>' })
+
+    vi.advanceTimersByTime(1000)
+    expect(lookupCache(id)).toBeNull()
+  })
+
+  it('does not reuse the response if the queries it varies by are changed', () => {
+    window.history.replaceState({}, '', '/?lang=en&page=1')
+    const id = requestCacheID('/page?__execute_event__=vary', post())
+    storeCache(id, { body: '<div>en</div>', cache: { ttl: 60000, varyByQuery: ['lang'] } })
+
+    window.history.replaceState({}, '', '/?lang=en&page=2')
+    expect(lookupCache(id)).toEqual({ body: '<div>en</div>' })
+
+    window.history.replaceState({}, '', '/?lang=de&page=2')
+    expect(lookupCache(id)).toBeNull()
+  })
+
+  it('drops the responses of the invalidated keys', () => {
+    const a = requestCacheID('/page?__execute_event__=a', post())
+    const b = requestCacheID('/page?__execute_event__=b', post())
+    storeCache(a, { body: 'a', cache: { ttl: 60000, key: 'orders' } })
+    storeCache(b, { body: 'b', cache: { ttl: 60000, key: 'customers' } })
+
+    invalidateCaches(['orders'])
+    expect(lookupCache(a)).toBeNull()
+    expect(lookupCache(b)).toEqual({ body: 'b' })
+  })
+})
@@ -1,6 +1,6 @@
 import { defineComponent, inject, nextTick, ref } from 'vue'
 import { flushPromises, mount } from '@vue/test-utils'
-import { describe, it, expect } from 'vitest'
+import { describe, it, expect, vi } from 'vitest'
 import { mockFetchWithReturnTemplate, mountTemplate, waitUntil } from './testutils'
 declare let window: any
 
@@ -294,4 +294,318 @@ describe('portal', () => {
 
     expect(wrapper.find('.go-plaid-portal').attributes().style).toEqual('height: 400px;')
   })
+  it('portal update modes and keyed items', async () => {
+    const wrapper = mountTemplate(`
+      <div class="feed">
+        <go-plaid-portal portal-name="feed" :visible="true">
+          <p id="first">first</p>
+        </go-plaid-portal>
+        <button @click='plaid().eventFunc("update").go()'>update</button>
+      </div>
+    `)
+    await nextTick()
+    const form = ref(new FormData())
+    const update = async (updatePortals: any[]) => {
+      mockFetchWithReturnTemplate(form, { updatePortals })
+      await wrapper.find('button').trigger('click')
+      await flushPromises()
+    }
+    const texts = () => wrapper.findAll('.feed p').map((p) => p.text())
+
+    await update([
+      { name: 'feed', mode: 'append', key: 'a', body: '<p><input id="a" v-model="locals.a" /></p>' },
+      { name: 'feed', mode: 'append', key: 'b', body: '<p>b</p>' },
+      { name: 'feed', mode: 'prepend', key: 'z', body: '<p>z</p>' }
+    ])
+    expect(texts()).toEqual(['z', 'first', '', 'b'])
+
+    // the existing children are not re-rendered, so the state of the input is kept
+    const input = wrapper.find('#a')
+    await input.setValue('typed')
+    await update([
+      { name: 'feed', mode: 'append', key: 'c', body: '<p>c</p>' },
+      { name: 'feed', mode: 'replace', key: 'b', body: '<p>b2</p>' },
+      { name: 'feed', mode: 'append', key: 'z', body: '<p>z2</p>' },
+      { name: 'feed', mode: 'remove', key: 'x' },
+      // the replace of a missing key is ignored
+      { name: 'feed', mode: 'replace', key: 'y', body: '<p>y</p>' }
+    ])
+    expect(texts()).toEqual(['z2', 'first', '', 'b2', 'c'])
+    expect(wrapper.find('#a').element).toBe(input.element)
+    expect((wrapper.find('#a').element as HTMLInputElement).value).toEqual('typed')
+
+    await update([{ name: 'feed', mode: 'remove', key: 'a' }])
+    expect(texts()).toEqual(['z2', 'first', 'b2', 'c'])
+
+    // replacing the whole portal drops the items
+    await update([{ name: 'feed', body: '<p>replaced</p>' }])
+    expect(texts()).toEqual(['replaced'])
+
+    await update([{ name: 'feed', mode: 'remove' }])
+    expect(texts()).toEqual([])
+  })
+  it('portal update replace-outer', async () => {
+    const wrapper = mountTemplate(`
+      <div class="feed">
+        <go-plaid-portal portal-name="summary" :visible="true">
+          <p>first</p>
+        </go-plaid-portal>
+        <button @click='plaid().eventFunc("update").go()'>update</button>
+      </div>
+    `)
+    await nextTick()
+    const form = ref(new FormData())
+    const update = async (updatePortals: any[]) => {
+      mockFetchWithReturnTemplate(form, { updatePortals })
+      await wrapper.find('button').trigger('click')
+      await flushPromises()
+    }
+
+    await update([
+      {
+        name: 'summary',
+        mode: 'replace-outer',
+        body: '<section id="summary"><input id="title" /></section>',
+        afterLoaded: 'el.querySelector("#title").value = el.id'
+      }
+    ])
+    // the body takes the place of the portal element
+    expect(wrapper.find('.go-plaid-portal').exists()).toBe(false)
+    expect(wrapper.find('.feed > #summary').exists()).toBe(true)
+    expect((wrapper.find('#title').element as HTMLInputElement).value).toEqual('summary')
+
+    // the items are added around the body in place of the portal element
+    await update([
+      { name: 'summary', mode: 'append', key: 'a', body: '<p class="item">appended</p>' },
+      { name: 'summary', mode: 'prepend', key: 'b', body: '<p class="item">prepended</p>' }
+    ])
+    expect(wrapper.findAll('.feed > .item').map((w) => w.text())).toEqual([
+      'prepended',
+      'appended'
+    ])
+    expect(wrapper.find('.feed > #summary').exists()).toBe(true)
+    expect((wrapper.find('#title').element as HTMLInputElement).value).toEqual('summary')
+    await update([{ name: 'summary', mode: 'remove', key: 'a' }])
+    expect(wrapper.findAll('.feed > .item').map((w) => w.text())).toEqual(['prepended'])
+
+    // the later updates of the name still apply
+    await update([{ name: 'summary', body: '<p>replaced</p>' }])
+    expect(wrapper.find('#summary').exists()).toBe(false)
+    expect(wrapper.find('.feed > .go-plaid-portal').text()).toEqual('replaced')
+  })
+
+  it('portal update after loaded', async () => {
+    const wrapper = mountTemplate(`
+      <div>
+        <go-plaid-portal portal-name="form" :visible="true"></go-plaid-portal>
+        <button @click='plaid().eventFunc("edit").go()'>edit</button>
+      </div>
+    `)
+    await nextTick()
+    const form = ref(new FormData())
+    mockFetchWithReturnTemplate(form, {
+      updatePortals: [
+        {
+          name: 'form',
+          body: '<div><input id="title" /></div>',
+          afterLoaded:
+            '{ const target = el.querySelector("#title"); if (target) { target.value = "loaded in " + el.className; } }'
+        }
+      ]
+    })
+    await wrapper.find('button').trigger('click')
+    await flushPromises()
+    await nextTick()
+    expect((wrapper.find('#title').element as HTMLInputElement).value).toEqual(
+      'loaded in go-plaid-portal'
+    )
+  })
+
+  it('portal loads when in viewport', async () => {
+    let intersect: (entries: any[]) => void = () => {}
+    let options: any
+    window.IntersectionObserver = class {
+      constructor(callback: (entries: any[]) => void, opts: any) {
+        intersect = callback
+        options = opts
+      }
+      observe() {}
+      disconnect() {
+        intersect = () => {}
+      }
+    }
+
+    const form = ref(new FormData())
+    mockFetchWithReturnTemplate(form, { body: '<h3 id="chart">chart</h3>' })
+    const wrapper = mountTemplate(`
+      <div>
+        <go-plaid-portal
+          portal-name="chart"
+          :visible="true"
+          :loader='plaid().eventFunc("loadChart")'
+          :load-when-in-viewport="true"
+          root-margin="200px">
+          <template v-slot:placeholder><div class="skeleton"></div></template>
+        </go-plaid-portal>
+      </div>
+    `)
+    await nextTick()
+    await flushPromises()
+    expect(options).toEqual({ rootMargin: '200px' })
+    expect(wrapper.find('.skeleton').exists()).toBe(true)
+    expect(wrapper.find('#chart').exists()).toBe(false)
+
+    intersect([{ isIntersecting: false }])
+    await flushPromises()
+    expect(wrapper.find('#chart').exists()).toBe(false)
+
+    intersect([{ isIntersecting: true }])
+    await flushPromises()
+    expect(wrapper.find('#chart').text()).toEqual('chart')
+    expect(wrapper.find('.skeleton').exists()).toBe(false)
+    delete window.IntersectionObserver
+  })
+
+  it('portal which loads when in viewport polls after the first load', async () => {
+    let intersect: (entries: any[]) => void = () => {}
+    window.IntersectionObserver = class {
+      constructor(callback: (entries: any[]) => void) {
+        intersect = callback
+      }
+      observe() {}
+      disconnect() {
+        intersect = () => {}
+      }
+    }
+    let calls = 0
+    global.fetch = vi.fn().mockImplementation(() => {
+      calls++
+      return Promise.resolve(new Response(JSON.stringify({ body: `<h3 id="chart">${calls}</h3>` })))
+    })
+
+    const wrapper = mountTemplate(`
+      <go-plaid-portal
+        portal-name="chart"
+        :visible="true"
+        :loader='plaid().eventFunc("loadChart")'
+        :load-when-in-viewport="true"
+        :auto-reload-interval="20">
+      </go-plaid-portal>
+    `)
+    // the polls do not load the portal off screen
+    await new Promise((resolve) => setTimeout(resolve, 60))
+    expect(calls).toEqual(0)
+
+    intersect([{ isIntersecting: true }])
+    await waitUntil(() => calls >= 2)
+    expect(wrapper.find('#chart').exists()).toBe(true)
+
+    wrapper.unmount()
+    delete window.IntersectionObserver
+  })
+
+  it('portal shows the inlined body of the loader', async () => {
+    const form = ref(new FormData())
+    mockFetchWithReturnTemplate(form, { body: '<h3 id="chart">reloaded</h3>' })
+    const wrapper = mountTemplate(`
+      <div>
+        <go-plaid-portal
+          portal-name="inlined"
+          :visible="true"
+          :loader='plaid().eventFunc("loadChart")'>
+          <template v-slot:loaded><h3 id="chart">inlined</h3></template>
+        </go-plaid-portal>
+      </div>
+    `)
+    await nextTick()
+    await flushPromises()
+    expect(wrapper.find('#chart').text()).toEqual('inlined')
+
+    window.__goplaid.portals['inlined'].reload()
+    await flushPromises()
+    expect(wrapper.findAll('#chart').map((h) => h.text())).toEqual(['reloaded'])
+  })
+
+  it('portal shows the fallback after the retries of the failed loader', async () => {
+    let calls = 0
+    let failing = true
+    global.fetch = vi.fn().mockImplementation(() => {
+      calls++
+      if (failing) {
+        return Promise.resolve(new Response('internal error', { status: 500 }))
+      }
+      return Promise.resolve(new Response(JSON.stringify({ body: '<h3 id="chart">chart</h3>' })))
+    })
+
+    const wrapper = mountTemplate(`
+      <div>
+        <go-plaid-portal
+          portal-name="failing"
+          :visible="true"
+          :loader='plaid().eventFunc("loadChart")'
+          :retry="2"
+          :retry-backoff="1">
+          <template v-slot:fallback="{ error, reload }">
+            <p id="error">{{ error }}</p>
+            <button id="retry" @click="reload()">retry</button>
+          </template>
+        </go-plaid-portal>
+        <p id="other">other</p>
+      </div>
+    `)
+    await waitUntil(() => wrapper.find('#error').exists())
+    expect(calls).toEqual(3)
+    expect(wrapper.find('#error').text()).toEqual('500')
+    expect(wrapper.find('#other').exists()).toBe(true)
+
+    failing = false
+    await wrapper.find('#retry').trigger('click')
+    await waitUntil(() => wrapper.find('#chart').exists())
+    expect(wrapper.find('#error').exists()).toBe(false)
+  })
+
+  it('portal auto reload is paused when hidden and adjusted by the server', async () => {
+    const responses: any[] = [
+      { body: '<h3 id="job">running 1</h3>' },
+      { notModified: true },
+      { body: '<h3 id="job">done</h3>', autoReload: { stop: true } }
+    ]
+    let calls = 0
+    global.fetch = vi.fn().mockImplementation(() => {
+      const r = responses[Math.min(calls++, responses.length - 1)]
+      return Promise.resolve(new Response(JSON.stringify(r)))
+    })
+    let hidden = true
+    Object.defineProperty(document, 'hidden', { configurable: true, get: () => hidden })
+
+    const wrapper = mountTemplate(`
+      <go-plaid-portal
+        portal-name="job"
+        :visible="true"
+        :loader='plaid().eventFunc("loadJob")'
+        :auto-reload-interval="20">
+      </go-plaid-portal>
+    `)
+    await waitUntil(() => wrapper.find('#job').exists())
+    expect(calls).toEqual(1)
+
+    // no polls while hidden
+    await new Promise((resolve) => setTimeout(resolve, 60))
+    expect(calls).toEqual(1)
+
+    // the polls resume when visible, the not modified response keeps the body
+    hidden = false
+    document.dispatchEvent(new Event('visibilitychange'))
+    await waitUntil(() => calls >= 2)
+    await flushPromises()
+    expect(wrapper.find('#job').text()).toEqual('running 1')
+
+    // the server stops the polls
+    await waitUntil(() => wrapper.find('#job').text() === 'done')
+    await new Promise((resolve) => setTimeout(resolve, 60))
+    expect(calls).toEqual(3)
+
+    wrapper.unmount()
+    delete (document as any).hidden
+  })
 })
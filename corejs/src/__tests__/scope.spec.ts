@@ -232,4 +232,21 @@ describe('scope', () => {
     await btn.trigger('click')
     expect(txt.text()).toEqual(`888`)
   })
+
+  it('expose locals at the path in vars while mounted', async () => {
+    const wrapper = mountTemplate(`
+      <go-plaid-scope v-if="!vars.hide" :init='{count: 1}' :expose-as='["compos", "a:0"]'>
+      </go-plaid-scope>
+      <div id="count">{{ vars.compos && vars.compos["a:0"] ? vars.compos["a:0"].count : "none" }}</div>
+      <button id="incr" @click='vars.compos["a:0"].count++'></button>
+      <button id="hide" @click='vars.hide = true'></button>
+    `)
+    await nextTick()
+    expect(wrapper.find('#count').text()).toEqual('1')
+    await wrapper.find('#incr').trigger('click')
+    expect(wrapper.find('#count').text()).toEqual('2')
+    await wrapper.find('#hide').trigger('click')
+    await nextTick()
+    expect(wrapper.find('#count').text()).toEqual('none')
+  })
 })
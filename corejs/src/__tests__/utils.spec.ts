@@ -138,10 +138,41 @@ describe('utils', () => {
     ]
     const queryString = encodeObjectToQuery(exampleObject, queryTags)
     expect(queryString).toEqual(
-      'xpage=0&chi%2Cld=aa_100&xselected_ids=x%2C,y,z&display_columns=&order_bys=Name%7C_ASC,Age_DES%2CC&f_approved.gte=0001-01-01+00%3A00&f_name.ilike=felix'
+      'xpage=0&chi%2Cld[a]=aa&chi%2Cld[b]=100&xselected_ids=x%2C,y,z&display_columns=&order_bys[0][FieldName]=Name%7C&order_bys[0][Order%2CBy]=ASC&order_bys[1][Fiel%7CdName]=Age&order_bys[1][OrderBy]=DES%2CC&f_approved.gte=0001-01-01+00%3A00&f_name.ilike=felix'
     )
   })
 
+  it('encodeObjectToQuery with nested maps and structs', () => {
+    const obj = {
+      filter: {
+        status: 'active',
+        labels: { env: 'prod', 'team name': 'a&b' },
+        address: { city: 'Shanghai', tags: ['a,', 'b'], distance: null }
+      },
+      empty_filter: {},
+      addresses: [{ city: 'Suzhou' }, { city: 'Hangzhou', distance: 12 }]
+    }
+    const queryTags = [
+      { name: 'filter', json_name: 'filter', omitempty: false },
+      { name: 'empty_filter', json_name: 'empty_filter', omitempty: true },
+      { name: 'addresses', json_name: 'addresses', omitempty: true }
+    ]
+    expect(encodeObjectToQuery(obj, queryTags)).toEqual(
+      'filter[address][city]=Shanghai&filter[address][distance]=&filter[address][tags]=a%2C,b&filter[labels][env]=prod&filter[labels][team%20name]=a%26b&filter[status]=active&addresses[0][city]=Suzhou&addresses[1][city]=Hangzhou&addresses[1][distance]=12'
+    )
+  })
+
+  it('encodeObjectToQuery omits default values', () => {
+    const obj = { page_size: 20, order_by: 'name', columns: ['name', 'age'], keyword: '' }
+    const queryTags = [
+      { name: 'page_size', json_name: 'page_size', omitempty: false, default: '20' },
+      { name: 'order_by', json_name: 'order_by', omitempty: false, default: 'created_at' },
+      { name: 'columns', json_name: 'columns', omitempty: false, default: 'name,age' },
+      { name: 'keyword', json_name: 'keyword', omitempty: false }
+    ]
+    expect(encodeObjectToQuery(obj, queryTags)).toEqual('order_by=name&keyword=')
+  })
+
   it('isRawQuerySubset', () => {
     const sup = 'id=1&name=John&age=30&emails=a%2C,b,c'
     let sub = 'id=1&name=John&age=30'
@@ -0,0 +1,113 @@
+import type { EventResponse } from '@/types'
+
+declare let window: any
+
+// the event func of the pages with EnableBatch which executes the batched events, see web.BatchEventFuncID
+export const batchEventFuncID = '__batch__'
+
+// maxBatchEvents returns the limit of the events of the batches of the path told by the page,
+// or 0 if the page of the path doesn't accept the batches, see web.PageBuilder.EnableBatch
+function maxBatchEvents(path: string): number {
+  const batches = window.__goplaid?.batches
+  return (batches && batches[path]) || 0
+}
+
+interface BatchItem {
+  url: string // the path and the query posted in the batch
+  fetchURL: string
+  opts: RequestInit
+  fields: Record<string, string[]>
+  resolve: (r: Response) => void
+  reject: (err: any) => void
+}
+
+interface BatchResult {
+  response?: EventResponse
+  error?: string
+}
+
+// the items waiting for the batch of their pages
+const queues = new Map<string, BatchItem[]>()
+
+// formFields returns the fields of the body, or null if it can't be batched, e.g. it has files
+function formFields(opts: RequestInit): Record<string, string[]> | null {
+  if (opts.method !== 'POST' || opts.headers || !(opts.body instanceof FormData)) {
+    return null
+  }
+  const fields: Record<string, string[]> = {}
+  for (const [k, v] of opts.body.entries()) {
+    if (typeof v !== 'string') {
+      return null
+    }
+    ;(fields[k] = fields[k] || []).push(v)
+  }
+  return fields
+}
+
+// batchFetch fetches like fetch, but the events posted to the same page in the same tick are posted in one request
+// if the page accepts the batches, the error of an event rejects only the fetch of it
+export function batchFetch(url: string, opts: RequestInit): Promise<Response> {
+  const fields = formFields(opts)
+  const u = new URL(url, window.location.href)
+  if (!fields || u.origin !== window.location.origin || maxBatchEvents(u.pathname) <= 1) {
+    return fetch(url, opts)
+  }
+
+  return new Promise((resolve, reject) => {
+    let queue = queues.get(u.pathname)
+    if (!queue) {
+      queue = []
+      queues.set(u.pathname, queue)
+      Promise.resolve().then(() => flush(u.pathname))
+    }
+    queue.push({ url: u.pathname + u.search, fetchURL: url, opts, fields, resolve, reject })
+  })
+}
+
+function flush(path: string) {
+  const queue = queues.get(path) || []
+  queues.delete(path)
+  const size = maxBatchEvents(path)
+  for (let i = 0; i < queue.length; i += size) {
+    post(path, queue.slice(i, i + size))
+  }
+}
+
+function fetchItem(item: BatchItem) {
+  fetch(item.fetchURL, item.opts).then(item.resolve, item.reject)
+}
+
+function post(path: string, items: BatchItem[]) {
+  if (items.length === 1) {
+    fetchItem(items[0])
+    return
+  }
+
+  const body = new FormData()
+  body.set(batchEventFuncID, JSON.stringify(items.map(({ url, fields }) => ({ url, fields }))))
+  fetch(`${path}?__execute_event__=${batchEventFuncID}`, { method: 'POST', body })
+    .then((r) => {
+      if (!r.ok) {
+        throw new Error(`batch failed: ${r.status}`)
+      }
+      return r.json()
+    })
+    .then((r: EventResponse) => {
+      const results: BatchResult[] = r.data || []
+      items.forEach((item, i) => {
+        const result = results[i]
+        if (!result || result.error) {
+          item.reject(new Error(result?.error || 'no result of the batched event'))
+          return
+        }
+        item.resolve(
+          new Response(JSON.stringify(result.response), {
+            headers: { 'Content-Type': 'application/json' }
+          })
+        )
+      })
+    })
+    // the batch is not supported or rejected by the server, e.g. an older server or a smaller limit of the batches,
+    // so the events are fetched one by one as if they were not batched
+    .catch(() => items.forEach(fetchItem))
+}
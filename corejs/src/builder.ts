@@ -1,4 +1,11 @@
-import type { EventFuncID, EventResponse, Location, Queries, QueryValue } from './types'
+import type {
+  EventFuncID,
+  EventResponse,
+  Location,
+  Queries,
+  QueryValue,
+  StateUpdate
+} from './types'
 import {
   buildPushState,
   encodeObjectToQuery,
@@ -8,6 +15,8 @@ import {
   slug,
   findScrollableParent
 } from '@/utils'
+import { batchFetch } from '@/batch'
+import { invalidateCaches, lookupCache, requestCacheID, storeCache } from '@/cache'
 import * as Vue from 'vue'
 import querystring from 'query-string'
 import jsonpatch from 'fast-json-patch'
@@ -15,6 +24,9 @@ import lodash from 'lodash'
 
 declare let window: any
 
+// the query of the events posted by the portal loaders, see web.LoadPortalBodyName
+const loadPortalBodyName = '__load_portal_body__'
+
 export class Builder {
   _eventFuncID: EventFuncID = { id: '__reload__' }
   _url?: string
@@ -23,6 +35,7 @@ export class Builder {
   _locals?: any
   _dash?: any
   _loadPortalBody: boolean = false
+  _throwErrors: boolean = false
   _form?: any = {}
   _popstate?: boolean
   _pushState?: boolean
@@ -84,6 +97,13 @@ export class Builder {
     return this
   }
 
+  // throwErrors rejects the promise of go() with the failed responses and the errors instead of alerting them,
+  // e.g. the portal handles them by its fallback and retries
+  public throwErrors(v: boolean): Builder {
+    this._throwErrors = v
+    return this
+  }
+
   public locals(v: any): Builder {
     // console.log("locals", v)
     this._locals = v
@@ -268,19 +288,48 @@ export class Builder {
 
     window.dispatchEvent(new Event('fetchStart'))
     let fetchURL = this.buildFetchURL()
+    // the server responds the errors of the portal loaders as the status, see web.LoadPortalBodyName
+    if (this._loadPortalBody) {
+      fetchURL = `${fetchURL}&${loadPortalBodyName}=1`
+    }
     if (this._beforeFetch) {
       ;[fetchURL, fetchOpts] = this._beforeFetch({ b: this, url: fetchURL, opts: fetchOpts })
     }
-    return fetch(fetchURL, fetchOpts)
+    // the portal loaders responded with the cache are reused until they expire or are invalidated
+    const cacheID = this._loadPortalBody ? requestCacheID(fetchURL, fetchOpts) : ''
+    const cached = cacheID ? lookupCache(cacheID) : null
+    // the portals loaded in the same tick are batched into one request if the page accepts the batches
+    const fetched = cached
+      ? Promise.resolve(new Response(JSON.stringify(cached)))
+      : this._loadPortalBody
+        ? batchFetch(fetchURL, fetchOpts)
+        : fetch(fetchURL, fetchOpts)
+    return fetched
       .then((r) => {
         if (r.redirected) {
           document.location.replace(r.url)
           return {}
         }
 
+        // the portal shows its fallback or retries for the failed loads
+        if (this._throwErrors && !r.ok) {
+          throw new Error(`${r.status} ${r.statusText}`.trim())
+        }
+
         return r.json()
       })
       .then((r: EventResponse) => {
+        if (r.invalidateCaches && r.invalidateCaches.length > 0) {
+          invalidateCaches(r.invalidateCaches)
+        }
+        if (cacheID && r.cache && !cached) {
+          storeCache(cacheID, r)
+        }
+
+        if (r.states && r.states.length > 0) {
+          this.applyStates(r.states)
+        }
+
         if (r.runScript) {
           new Function('vars', 'locals', 'form', 'dash', 'plaid', r.runScript).apply(this, [
             this._vars,
@@ -321,9 +370,9 @@ export class Builder {
 
         if (r.updatePortals && r.updatePortals.length > 0) {
           for (const pu of r.updatePortals) {
-            const { updatePortalTemplate } = window.__goplaid.portals[pu.name]
-            if (updatePortalTemplate) {
-              updatePortalTemplate(pu.body)
+            const portal = window.__goplaid.portals[pu.name]
+            if (portal) {
+              portal.updatePortal(pu)
             }
           }
         }
@@ -349,6 +398,9 @@ export class Builder {
         return r
       })
       .catch((error) => {
+        if (this._throwErrors) {
+          throw error
+        }
         if (!this.isIgnoreError(error)) {
           alert('Unknown Error')
         }
@@ -382,6 +434,22 @@ export class Builder {
     }
   }
 
+  // applyStates sets the values of the response to the paths of the states, the empty path merges the value
+  public applyStates(states: StateUpdate[]) {
+    const targets = { vars: this._vars, locals: this._locals, dash: this._dash }
+    for (const s of states) {
+      const target = targets[s.target]
+      if (!target) {
+        continue
+      }
+      if (s.path) {
+        lodash.set(target, s.path, s.value)
+      } else {
+        Object.assign(target, s.value)
+      }
+    }
+  }
+
   public applyJsonPatch(obj: any, patch: any): any {
     return jsonpatch.applyPatch(obj, patch)
   }
@@ -392,7 +460,13 @@ export class Builder {
 
   public encodeObjectToQuery(
     obj: any,
-    queryTags: { name: string; json_name: string; omitempty: boolean; encoder?: Function }[]
+    queryTags: {
+      name: string
+      json_name: string
+      omitempty: boolean
+      default?: string
+      encoder?: Function
+    }[]
   ) {
     return encodeObjectToQuery(obj, queryTags)
   }
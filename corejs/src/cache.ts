@@ -0,0 +1,81 @@
+import type { EventResponse } from '@/types'
+
+declare let window: any
+
+const maxCacheEntries = 100
+
+interface CacheEntry {
+  key: string
+  expiresAt: number
+  vary: Record<string, string[]>
+  response: EventResponse
+}
+
+// the cached responses of the portal loaders by the requests, the oldest ones are dropped beyond maxCacheEntries
+const entries = new Map<string, CacheEntry>()
+
+function queryValues(names: string[]): Record<string, string[]> {
+  const params = new URLSearchParams(window.location.search)
+  const vary: Record<string, string[]> = {}
+  names.forEach((name) => (vary[name] = params.getAll(name)))
+  return vary
+}
+
+// requestCacheID returns the id of the request in the cache, or '' if it can't be cached, e.g. it posts files
+export function requestCacheID(url: string, opts: RequestInit): string {
+  const fields: [string, string][] = []
+  if (opts.body instanceof FormData) {
+    for (const [k, v] of opts.body.entries()) {
+      if (typeof v !== 'string') {
+        return ''
+      }
+      fields.push([k, v])
+    }
+  } else if (opts.body) {
+    return ''
+  }
+  return `${opts.method || 'GET'} ${url} ${JSON.stringify(fields)}`
+}
+
+export function lookupCache(id: string): EventResponse | null {
+  const entry = entries.get(id)
+  if (!entry) {
+    return null
+  }
+  if (
+    entry.expiresAt <= Date.now() ||
+    JSON.stringify(queryValues(Object.keys(entry.vary))) !== JSON.stringify(entry.vary)
+  ) {
+    entries.delete(id)
+    return null
+  }
+  return entry.response
+}
+
+export function storeCache(id: string, r: EventResponse) {
+  if (!r.cache || !(r.cache.ttl > 0)) {
+    return
+  }
+  const response = { ...r }
+  // the cached response is not cached or invalidating again when it is reused
+  delete response.cache
+  delete response.invalidateCaches
+  entries.delete(id)
+  entries.set(id, {
+    key: r.cache.key || '',
+    expiresAt: Date.now() + r.cache.ttl,
+    vary: queryValues(r.cache.varyByQuery || []),
+    response
+  })
+  while (entries.size > maxCacheEntries) {
+    entries.delete(entries.keys().next().value as string)
+  }
+}
+
+export function invalidateCaches(keys: string[]) {
+  for (const [id, entry] of entries) {
+    if (entry.key && keys.includes(entry.key)) {
+      entries.delete(id)
+    }
+  }
+}
@@ -27,14 +27,26 @@ export interface EventFuncID {
   location?: Location
 }
 
+export type PortalUpdateMode = 'replace' | 'replace-outer' | 'append' | 'prepend' | 'remove'
+
 export interface PortalUpdate {
   name: string
   body: string
   afterLoaded?: string
+  mode?: PortalUpdateMode
+  key?: string
+}
+
+export type StateTarget = 'vars' | 'locals' | 'dash'
+
+export interface StateUpdate {
+  target: StateTarget
+  path?: string
+  value: any
 }
 
 export interface EventResponse {
-  states?: any
+  states?: StateUpdate[]
   body?: any
   data?: any
   redirectURL?: string
@@ -44,4 +56,19 @@ export interface EventResponse {
   reloadPortals?: string[]
   updatePortals?: PortalUpdate[]
   runScript?: string
+  notModified?: boolean
+  autoReload?: AutoReload
+  cache?: ResponseCache
+  invalidateCaches?: string[]
+}
+
+export interface ResponseCache {
+  ttl: number
+  key?: string
+  varyByQuery?: string[]
+}
+
+export interface AutoReload {
+  interval?: number
+  stop?: boolean
 }
@@ -211,7 +211,7 @@ export function componentByTemplate(
     },
     mounted() {
       this.$nextTick(() => /**/ {
-        if (this.$el && this.$el.style && this.$el.style.height) {
+        if (this.$el && this.$el.style && this.$el.style.height && portal.value) {
           portal.value.style.height = this.$el.style.height
         }
       })
@@ -253,42 +253,64 @@ export function objectToFormData(obj: any, form: FormData, parentKey = '') {
 
 export function encodeObjectToQuery(
   obj: any,
-  queryTags: { name: string; json_name: string; omitempty: boolean; encoder?: Function }[]
+  queryTags: {
+    name: string
+    json_name: string
+    omitempty: boolean
+    default?: string
+    encoder?: Function
+  }[]
 ) {
   if (queryTags.length === 0) {
     return ''
   }
 
-  const processObject = (obj: any) => {
-    return Object.keys(obj)
-      .sort()
-      .map((key) => {
-        const encodedValue = encodeURIComponent(obj[key])
-        if (encodedValue.includes('_')) {
-          throw new Error(`Value contains underscore (_) which is not allowed: ${encodedValue}`)
-        }
-        return encodedValue
-      })
-      .join('_')
+  // maps, structs and slices of structs use the bracket notation like qs, e.g. filter[status]=active,
+  // which is decoded by QueryTags.Decode in Go, slices of scalars are comma separated
+  const isObject = (v: any) => v !== null && typeof v === 'object'
+
+  const processArray = (arr: any[]) => {
+    return arr.map((item) => encodeURIComponent(item ?? '')).join(',')
   }
 
-  const processArray = (arr: []) => {
-    return arr
-      .map((item) => {
-        if (typeof item === 'object' && !Array.isArray(item)) {
-          return processObject(item)
-        } else {
-          return encodeURIComponent(item)
-        }
-      })
-      .join(',')
+  const processNested = (prefix: string, value: any) => {
+    if (value === undefined) {
+      return
+    }
+    if (value === null) {
+      queries.push(`${prefix}=`)
+    } else if (Array.isArray(value)) {
+      if (value.some(isObject)) {
+        value.forEach((item, i) => processNested(`${prefix}[${i}]`, item))
+      } else {
+        queries.push(`${prefix}=${processArray(value)}`)
+      }
+    } else if (isObject(value)) {
+      Object.keys(value)
+        .sort()
+        .forEach((key) => processNested(`${prefix}[${encodeURIComponent(key)}]`, value[key]))
+    } else {
+      queries.push(`${prefix}=${encodeURIComponent(value)}`)
+    }
+  }
+
+  // the values equal to the default are left out, it is the value the server restores if absent,
+  // the default tag or the initial value of the compo, see QueryDefaults of stateful.Action
+  const isDefault = (tag: { default?: string }, value: any) => {
+    if (tag.default === undefined || value === null) {
+      return false
+    }
+    if (Array.isArray(value)) {
+      return !value.some(isObject) && value.map((item) => `${item ?? ''}`).join(',') === tag.default
+    }
+    return !isObject(value) && `${value}` === tag.default
   }
 
   const queries: string[] = []
 
   queryTags.forEach((tag) => {
     const value: any = obj[tag.json_name]
-    if (value === undefined) {
+    if (value === undefined || isDefault(tag, value)) {
       return
     }
 
@@ -304,12 +326,16 @@ export function encodeObjectToQuery(
     if (value === null) {
       queries.push(`${key}=`)
     } else if (Array.isArray(value)) {
-      if (tag.omitempty && obj[tag.json_name].length === 0) {
+      if (tag.omitempty && value.length === 0) {
+        return
+      }
+      if (value.length > 0 && value.some(isObject)) {
+        processNested(key, value)
         return
       }
-      queries.push(`${key}=${processArray(obj[tag.json_name])}`)
+      queries.push(`${key}=${processArray(value)}`)
     } else if (typeof value === 'object') {
-      queries.push(`${key}=${processObject(value)}`)
+      processNested(key, value)
     } else {
       queries.push(`${key}=${encodeURIComponent(value)}`)
     }
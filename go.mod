@@ -5,6 +5,7 @@ go 1.25.8
 require (
 	github.com/NYTimes/gziphandler v1.1.1
 	github.com/go-playground/form/v4 v4.2.1
+	github.com/go-playground/validator/v10 v10.30.3
 	github.com/iancoleman/strcase v0.3.0
 	github.com/samber/lo v1.40.0
 	github.com/spaolacci/murmur3 v1.1.0
@@ -16,13 +17,18 @@ require (
 	github.com/theplant/osenv v0.0.2
 	github.com/theplant/testingutils v0.0.2
 	github.com/wI2L/jsondiff v0.6.0
+	golang.org/x/net v0.56.0
 	golang.org/x/sync v0.21.0
 )
 
 require (
 	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/gabriel-vasile/mimetype v1.4.13 // indirect
+	github.com/go-playground/locales v0.14.1 // indirect
+	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
+	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.12.0 // indirect
@@ -31,8 +37,9 @@ require (
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
 	github.com/yosssi/gohtml v0.0.0-20201013000340-ee4748c638f4 // indirect
+	golang.org/x/crypto v0.53.0 // indirect
 	golang.org/x/exp v0.0.0-20220303212507-bbda1eaf7a17 // indirect
-	golang.org/x/net v0.56.0 // indirect
+	golang.org/x/sys v0.46.0 // indirect
 	golang.org/x/text v0.39.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,177 @@
+package web
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	h "github.com/theplant/htmlgo"
+)
+
+// JS builds the javascript expressions as Var, which are accepted by the apis taking the scripts,
+// e.g. Scope.Init, Scope.OnChangeJS, VAssign, RunScriptJS, ListenJS and VueEventTagBuilder.Run.
+// The Go values in the expressions are encoded as JSON, so they can't break out of the scripts,
+// the strings are never taken as the scripts, use Raw for the scripts written by hand.
+//
+//	web.JS.Var("locals").Get("count").Assign(web.JS.Var("locals").Get("count").Add(1))
+var JS jsBuilder
+
+type jsBuilder struct{}
+
+var jsIdentifierPath = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*)*$`)
+
+var jsIdentifier = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// Var is the variable of the name, e.g. "locals" or "$event.target.value", it panics if the name is not an identifier
+func (jsBuilder) Var(name string) Var {
+	if !jsIdentifierPath.MatchString(name) {
+		panic(fmt.Sprintf("invalid javascript variable %q", name))
+	}
+	return Var(name)
+}
+
+// Value is the literal of the Go value encoded as JSON, the Var is kept as is
+func (jsBuilder) Value(v interface{}) Var {
+	return Var(toJsValue(v))
+}
+
+// Raw is the script as is, it must not contain the values from the users
+func (jsBuilder) Raw(script string) Var {
+	return Var(script)
+}
+
+// Object is the object literal of the fields, the values of them could be the Var or the Go values
+func (jsBuilder) Object(fields map[string]interface{}) Var {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var fs []string
+	for _, k := range keys {
+		fs = append(fs, fmt.Sprintf("%s: %s", h.JSONString(k), toJsValue(fields[k])))
+	}
+	return Var("{" + strings.Join(fs, ", ") + "}")
+}
+
+// Array is the array literal of the items, the items could be the Var or the Go values
+func (jsBuilder) Array(items ...interface{}) Var {
+	return Var("[" + jsArgs(items) + "]")
+}
+
+// Seq is the statements run in order
+func (jsBuilder) Seq(stmts ...Var) Var {
+	var ss []string
+	for _, s := range stmts {
+		ss = append(ss, string(s))
+	}
+	return Var(strings.Join(ss, "; "))
+}
+
+// Func is the arrow function of the params, the body of it is set by JSFunc.Body
+func (jsBuilder) Func(params ...string) *JSFunc {
+	for _, p := range params {
+		if !jsIdentifier.MatchString(p) {
+			panic(fmt.Sprintf("invalid javascript param %q", p))
+		}
+	}
+	return &JSFunc{params: params}
+}
+
+type JSFunc struct {
+	params []string
+}
+
+// Body is the function of the statements, the result of the last one is not returned, use Return for it
+func (f *JSFunc) Body(stmts ...Var) Var {
+	return Var(fmt.Sprintf("(%s) => { %s }", strings.Join(f.params, ", "), JS.Seq(stmts...)))
+}
+
+// Return is the function returning the expression
+func (f *JSFunc) Return(v interface{}) Var {
+	return Var(fmt.Sprintf("(%s) => (%s)", strings.Join(f.params, ", "), toJsValue(v)))
+}
+
+// Get is the property of the path, the strings are the keys and the ints are the indexes
+func (v Var) Get(path ...interface{}) Var {
+	r := string(v)
+	for _, p := range path {
+		switch pt := p.(type) {
+		case string:
+			if jsIdentifier.MatchString(pt) {
+				r += "." + pt
+				continue
+			}
+		case Var:
+			r += "[" + string(pt) + "]"
+			continue
+		}
+		r += "[" + h.JSONString(p) + "]"
+	}
+	return Var(r)
+}
+
+// Call calls the function with the args, the Go values of them are encoded as JSON
+func (v Var) Call(args ...interface{}) Var {
+	return Var(fmt.Sprintf("%s(%s)", v, jsArgs(args)))
+}
+
+// Method calls the method of the name with the args
+func (v Var) Method(name string, args ...interface{}) Var {
+	return v.Get(name).Call(args...)
+}
+
+func (v Var) Assign(value interface{}) Var {
+	return Var(fmt.Sprintf("%s = %s", v, toJsValue(value)))
+}
+
+func (v Var) Eq(value interface{}) Var {
+	return v.binary("===", value)
+}
+
+func (v Var) Add(value interface{}) Var {
+	return v.binary("+", value)
+}
+
+func (v Var) And(value interface{}) Var {
+	return v.binary("&&", value)
+}
+
+func (v Var) Or(value interface{}) Var {
+	return v.binary("||", value)
+}
+
+func (v Var) Not() Var {
+	return Var(fmt.Sprintf("!(%s)", v))
+}
+
+func (v Var) binary(op string, value interface{}) Var {
+	return Var(fmt.Sprintf("(%s %s %s)", v, op, toJsValue(value)))
+}
+
+func (v Var) String() string {
+	return string(v)
+}
+
+func jsArgs(args []interface{}) string {
+	var as []string
+	for _, a := range args {
+		as = append(as, toJsValue(a))
+	}
+	return strings.Join(as, ", ")
+}
+
+// jsScript returns the string or the Var as the script, the other values are encoded as JSON,
+// it is only for the apis which took the strings as the scripts before Var, e.g. Scope.Init and VAssign
+func jsScript(v interface{}) string {
+	switch vt := v.(type) {
+	case string:
+		return vt
+	case Var:
+		return string(vt)
+	default:
+		return h.JSONString(v)
+	}
+}
@@ -0,0 +1,106 @@
+package web_test
+
+import (
+	"testing"
+
+	h "github.com/theplant/htmlgo"
+
+	. "github.com/qor5/web/v3"
+)
+
+func TestJS(t *testing.T) {
+	locals := JS.Var("locals")
+	cases := []struct {
+		name     string
+		expr     Var
+		expected string
+	}{
+		{
+			name:     "assign escaped value",
+			expr:     locals.Get("user", "name").Assign(`"</script><script>alert(1)</script>`),
+			expected: `locals.user.name = "\"\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e"`,
+		},
+		{
+			name:     "get keys indexes and expressions",
+			expr:     locals.Get("items", 0, "first-name", JS.Var("vars").Get("key")),
+			expected: `locals.items[0]["first-name"][vars.key]`,
+		},
+		{
+			name:     "operators",
+			expr:     locals.Get("count").Assign(locals.Get("count").Add(1)),
+			expected: `locals.count = (locals.count + 1)`,
+		},
+		{
+			name:     "calls",
+			expr:     JS.Var("vars").Method("emit", "updated", map[string]int{"id": 1}, JS.Var("$event")),
+			expected: `vars.emit("updated", {"id":1}, $event)`,
+		},
+		{
+			name: "function",
+			expr: JS.Func("payload").Body(
+				locals.Get("loading").Assign(true),
+				JS.Raw(Plaid().EventFunc("load").Query("id", JS.Var("payload").Get("id")).Go()),
+			),
+			expected: `(payload) => { locals.loading = true; plaid().vars(vars).locals(locals).form(form).dash(dash).eventFunc("load").query("id", payload.id).go() }`,
+		},
+		{
+			name:     "function returning",
+			expr:     JS.Func("a", "b").Return(JS.Var("a").Eq(JS.Var("b")).Not()),
+			expected: `(a, b) => (!((a === b)))`,
+		},
+		{
+			name:     "object and array",
+			expr:     JS.Object(map[string]interface{}{"name": "<b>", "items": JS.Array(1, JS.Var("vars").Get("x"))}),
+			expected: `{"items": [1, vars.x], "name": "\u003cb\u003e"}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.expr.String() != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, c.expr)
+			}
+		})
+	}
+}
+
+func TestJSInvalidVar(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for the invalid variable")
+		}
+	}()
+	JS.Var("locals; alert(1)")
+}
+
+func TestJSAcceptedByScripts(t *testing.T) {
+	locals := JS.Var("locals")
+	comp := h.Components(
+		Scope().
+			Init(JS.Object(map[string]interface{}{"count": 0, "name": "a'b"})).
+			OnChangeJS(locals.Get("count").Assign(1)).
+			VSlot("{ locals }"),
+		h.Input("").Attr(VAssign("locals", JS.Object(map[string]interface{}{"now": JS.Var("Date").Method("now")}))...),
+		RunScriptJS(JS.Var("console").Method("log", "mounted")),
+		ListenJS("updated", JS.Func("payload").Body(locals.Get("count").Assign(JS.Var("payload").Get("count")))),
+		h.Button("").Attr("@click", Plaid().EventFunc("save").
+			BeforeScriptJS(locals.Get("saving").Assign(true)).
+			ThenScriptJS(locals.Get("saving").Assign(false)).
+			Go()),
+	)
+
+	expected := `
+<go-plaid-scope :init='{"count": 0, "name": "a&#39;b"}' @change-debounced='({locals, form, oldLocals, oldForm}) => { locals.count = 1 }' :use-debounce='800' v-slot='{ locals }'></go-plaid-scope>
+
+<input v-assign='[locals, {"now": Date.now()}]'>
+
+<div v-on-mounted='console.log("mounted")' style='display: none;'></div>
+
+<go-plaid-listener @updated='(payload) => { locals.count = payload.count }'></go-plaid-listener>
+
+<button @click='locals.saving = true; plaid().vars(vars).locals(locals).form(form).dash(dash).eventFunc("save").go().then(function(r){ locals.saving = false })'></button>
+`
+	if actual := h.MustString(comp, nil); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
@@ -29,6 +29,8 @@ type TestPortalUpdate struct {
 	Name        string `json:"name,omitempty"`
 	Body        string `json:"body,omitempty"`
 	AfterLoaded string `json:"afterLoaded,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	Key         string `json:"key,omitempty"`
 }
 
 type TestLocationBuilder struct {
@@ -39,15 +41,37 @@ type TestLocationBuilder struct {
 }
 
 type TestEventResponse struct {
-	PageTitle     string               `json:"pageTitle,omitempty"`
-	Body          string               `json:"body,omitempty"`
-	Reload        bool                 `json:"reload,omitempty"`
-	PushState     *TestLocationBuilder `json:"pushState"`
-	RedirectURL   string               `json:"redirectURL,omitempty"`
-	ReloadPortals []string             `json:"reloadPortals,omitempty"`
-	UpdatePortals []*TestPortalUpdate  `json:"updatePortals,omitempty"`
-	Data          interface{}          `json:"data,omitempty"`
-	RunScript     string               `json:"runScript,omitempty"`
+	PageTitle        string               `json:"pageTitle,omitempty"`
+	Body             string               `json:"body,omitempty"`
+	Reload           bool                 `json:"reload,omitempty"`
+	PushState        *TestLocationBuilder `json:"pushState"`
+	RedirectURL      string               `json:"redirectURL,omitempty"`
+	ReloadPortals    []string             `json:"reloadPortals,omitempty"`
+	UpdatePortals    []*TestPortalUpdate  `json:"updatePortals,omitempty"`
+	Data             interface{}          `json:"data,omitempty"`
+	RunScript        string               `json:"runScript,omitempty"`
+	NotModified      bool                 `json:"notModified,omitempty"`
+	AutoReload       *TestAutoReload      `json:"autoReload,omitempty"`
+	Cache            *TestResponseCache   `json:"cache,omitempty"`
+	InvalidateCaches []string             `json:"invalidateCaches,omitempty"`
+	States           []*TestStateUpdate   `json:"states,omitempty"`
+}
+
+type TestStateUpdate struct {
+	Target string `json:"target"`
+	Path   string `json:"path,omitempty"`
+	Value  any    `json:"value"`
+}
+
+type TestResponseCache struct {
+	TTL         int64    `json:"ttl"`
+	Key         string   `json:"key,omitempty"`
+	VaryByQuery []string `json:"varyByQuery,omitempty"`
+}
+
+type TestAutoReload struct {
+	Interval int64 `json:"interval,omitempty"`
+	Stop     bool  `json:"stop,omitempty"`
 }
 
 func RunCase(t *testing.T, c TestCase, handler http.Handler) {
@@ -25,9 +25,13 @@ func Page(pf PageFunc, efs ...interface{}) (p *PageBuilder) {
 
 type PageBuilder struct {
 	EventsHub
-	b                *Builder
-	pageRenderFunc   PageFunc
-	eventFuncWrapper func(in EventFunc) EventFunc
+	b                   *Builder
+	pageRenderFunc      PageFunc
+	eventFuncWrapper    func(in EventFunc) EventFunc
+	inlinePortalLoaders bool
+	batchEnabled        bool
+	batchConcurrency    int
+	batchMaxEvents      int
 }
 
 func (b *Builder) Page(pf PageFunc) (p *PageBuilder) {
@@ -72,6 +76,16 @@ func (p *PageBuilder) WrapEventFunc(w func(in EventFunc) EventFunc) (r *PageBuil
 	return p
 }
 
+// InlinePortalLoaders runs the event funcs of the portal loaders in the first render of the page and inlines their bodies,
+// so the page is complete without fetching the portals, e.g. for the crawlers. The later reloads of the portals still post the events.
+// The loaders are inlined only if they don't depend on the browser, see PortalBuilder.Loader,
+// and their responses have only the body, the ones which also set e.g. RunScript, States or UpdatePortals
+// are left to the browser, as are the ones which fail or panic.
+func (p *PageBuilder) InlinePortalLoaders(v bool) (r *PageBuilder) {
+	p.inlinePortalLoaders = v
+	return p
+}
+
 func (p *PageBuilder) MergeHub(hub *EventsHub) (r *PageBuilder) {
 	p.EventsHub.eventFuncs = append(hub.eventFuncs, p.EventsHub.eventFuncs...)
 	return p
@@ -86,7 +100,7 @@ func (p *PageBuilder) render(
 	}
 	rf := p.pageRenderFunc
 	if !event {
-		rf = p.b.layoutFunc(p.pageRenderFunc)
+		rf = p.b.layoutFunc(renderBodyFirst(p.pageRenderFunc))
 	}
 
 	pr, err := rf(ctx)
@@ -117,6 +131,13 @@ func (p *PageBuilder) index(w http.ResponseWriter, r *http.Request) {
 	ctx.W = w
 	ctx.Injector = &PageInjector{}
 	ctx.withSelf()
+	defer withAfterResponse(ctx).run()
+	if p.inlinePortalLoaders {
+		ctx.WithContextValue(portalLoaderInlinerKey, p.portalLoaderInliner(ctx))
+	}
+	if p.batchEnabled {
+		p.injectBatchScript(ctx)
+	}
 	_, body := p.render(ctx, false)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -128,44 +149,61 @@ func (p *PageBuilder) index(w http.ResponseWriter, r *http.Request) {
 
 const EventFuncIDName = "__execute_event__"
 
+// LoadPortalBodyName is the query of the events posted by the portal loaders,
+// the errors of them are responded as the status instead of panicking, so the portals show their fallbacks or retry
+const LoadPortalBodyName = "__load_portal_body__"
+
 func (p *PageBuilder) executeEvent(w http.ResponseWriter, r *http.Request) {
 	ctx := new(EventContext)
 	ctx.R = r
 	ctx.W = w
 	ctx.Injector = &PageInjector{}
 	ctx.withSelf()
+	defer withAfterResponse(ctx).run()
 
 	eventFuncID := ctx.R.FormValue(EventFuncIDName)
 
 	// for server side restart and lost all the eventFuncs,
 	// but user keep clicking page without refresh page to call p.render to fill up eventFuncs
-	// because default added reload
-	if len(p.eventFuncs) <= 1 &&
+	if p.onlyDefaultEventFuncs() &&
 		p.eventFuncById(eventFuncID) == nil &&
 		p.b.eventFuncById(eventFuncID) == nil {
 		log.Println("Re-render because event funcs gone, might server restarted")
 		p.render(ctx, true)
 	}
 
-	ef := p.eventFuncById(eventFuncID)
-	if ef == nil {
-		ef = p.b.eventFuncById(eventFuncID)
-	}
-
+	ef := p.lookupEventFunc(eventFuncID)
 	if ef == nil {
 		log.Printf("event %s not found in %s\n", eventFuncID, p.EventsHub.String())
 		http.NotFound(ctx.W, ctx.R)
 		return
 	}
 
-	if p.eventFuncWrapper != nil {
-		ef = p.eventFuncWrapper(ef)
-	}
+	scripts := withEventScripts(ctx)
 	er, err := ef(ctx)
+	if err != nil {
+		if ctx.R.URL.Query().Has(LoadPortalBodyName) {
+			log.Printf("event %s failed: %v\n", eventFuncID, err)
+			http.Error(ctx.W, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		panic(err)
+	}
+	if er.Reload && p.batchEnabled {
+		p.injectBatchScript(ctx)
+	}
+	p.renderEventResponse(ctx, &er)
+	scripts.prependTo(&er)
+
+	ctx.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(ctx.W).Encode(er)
 	if err != nil {
 		panic(err)
 	}
+}
 
+// renderEventResponse renders the page of the reload and the bodies to html
+func (p *PageBuilder) renderEventResponse(ctx *EventContext, er *EventResponse) {
 	if er.Reload {
 		pr, body := p.render(ctx, true)
 		er.Body = h.RawHTML(body)
@@ -179,12 +217,18 @@ func (p *PageBuilder) executeEvent(w http.ResponseWriter, r *http.Request) {
 	for _, up := range er.UpdatePortals {
 		up.Body = h.RawHTML(h.MustString(up.Body, ctx.R.Context()))
 	}
+}
 
-	ctx.W.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(ctx.W).Encode(er)
-	if err != nil {
-		panic(err)
+// lookupEventFunc returns the event func of the page or the builder wrapped by the page, it returns nil if not found
+func (p *PageBuilder) lookupEventFunc(id string) (r EventFunc) {
+	r = p.eventFuncById(id)
+	if r == nil {
+		r = p.b.eventFuncById(id)
+	}
+	if r != nil && p.eventFuncWrapper != nil {
+		r = p.eventFuncWrapper(r)
 	}
+	return
 }
 
 func reload(ctx *EventContext) (r EventResponse, err error) {
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	h "github.com/theplant/htmlgo"
+)
+
+type injectedScriptKey string
+
+type eventScriptsKey struct{}
+
+// eventScripts are the scripts injected in the render of an event response, they are run before its RunScript
+type eventScripts struct {
+	mu      sync.Mutex
+	keys    map[string]bool
+	scripts []string
+}
+
+func (s *eventScripts) add(key string, script string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys[key] {
+		return
+	}
+	s.keys[key] = true
+	s.scripts = append(s.scripts, script)
+}
+
+// InjectScript runs the script once per key for the page or the event response rendered with ctx,
+// e.g. a registry shared by the components of a type, so it runs even if none of the components is mounted.
+// The script is injected into the head of the page in the page render, and before the RunScript of the event response
+// in the event render. It runs in the global scope without the vars, use window for the shared values.
+func InjectScript(ctx context.Context, key string, script string) {
+	evCtx, ok := GetEventContext(ctx)
+	if !ok {
+		return
+	}
+	if s, ok := evCtx.ContextValue(eventScriptsKey{}).(*eventScripts); ok {
+		s.add(key, script)
+		return
+	}
+	if evCtx.Injector != nil {
+		evCtx.Injector.HeadHTMLComponent(injectedScriptKey(key), h.Script(script), false)
+	}
+}
+
+// withEventScripts collects the scripts injected in the event func and the render of its response
+func withEventScripts(ctx *EventContext) *eventScripts {
+	s := &eventScripts{keys: map[string]bool{}}
+	ctx.WithContextValue(eventScriptsKey{}, s)
+	return s
+}
+
+// prependTo runs the injected scripts before the RunScript of the response
+func (s *eventScripts) prependTo(er *EventResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.scripts) == 0 {
+		return
+	}
+	scripts := s.scripts
+	if er.RunScript != "" {
+		scripts = append(scripts, er.RunScript)
+	}
+	er.RunScript = strings.Join(scripts, "; ")
+}
+
+// renderBodyFirst renders the body of the page before the layout,
+// so the components in the body can inject into the head and the tail of the page
+func renderBodyFirst(in PageFunc) PageFunc {
+	return func(ctx *EventContext) (r PageResponse, err error) {
+		r, err = in(ctx)
+		if err != nil || r.Body == nil {
+			return
+		}
+		b, err := r.Body.MarshalHTML(ctx.R.Context())
+		if err != nil {
+			return
+		}
+		r.Body = h.RawHTML(b)
+		return
+	}
+}
@@ -0,0 +1,52 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	h "github.com/theplant/htmlgo"
+
+	. "github.com/qor5/web/v3"
+)
+
+func scriptInjectingComp(name string) h.HTMLComponent {
+	return h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+		InjectScript(ctx, "registry", "window.registry = {}")
+		return h.Div().Text(name).MarshalHTML(ctx)
+	})
+}
+
+func TestInjectScript(t *testing.T) {
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		pr.Body = h.Components(scriptInjectingComp("a"), scriptInjectingComp("b"))
+		return
+	}).EventFunc("update", func(ctx *EventContext) (r EventResponse, err error) {
+		r.Body = scriptInjectingComp("a")
+		r.UpdatePortals = append(r.UpdatePortals, &PortalUpdate{Name: "b", Body: scriptInjectingComp("b")})
+		r.RunScript = "vars.updated = true"
+		return
+	})
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	script := `<script type='text/javascript'>window.registry = {}</script>`
+	if strings.Count(body, script) != 1 || strings.Index(body, script) > strings.Index(body, "</head>") {
+		t.Error("the script is not injected into the head once", body)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/?__execute_event__=update", nil))
+	var er struct {
+		RunScript string `json:"runScript"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &er); err != nil {
+		t.Fatal(err)
+	}
+	if er.RunScript != "window.registry = {}; vars.updated = true" {
+		t.Errorf("the script is not run once before the run script, got %q", er.RunScript)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	h "github.com/theplant/htmlgo"
 	"github.com/theplant/htmltestingutils"
@@ -190,6 +191,97 @@ var eventCases = []struct {
 }`,
 	},
 
+	{
+		name: "update portals with modes and keys",
+		eventFunc: func(ctx *EventContext) (r EventResponse, err error) {
+			r.UpdatePortals = append(r.UpdatePortals,
+				&PortalUpdate{Name: "messages", Body: h.Div().Text("new"), Mode: PortalUpdateModePrepend, Key: "m2"},
+				&PortalUpdate{Name: "messages", Mode: PortalUpdateModeRemove, Key: "m1"},
+				&PortalUpdate{Name: "summary", Body: h.Div().Text("total"), Mode: PortalUpdateModeReplaceOuter},
+			)
+			return
+		},
+		expectedEventResp: `{
+	"body": "",
+	"pushState": null,
+	"updatePortals": [
+		{
+			"name": "messages",
+			"body": "\n<div>new</div>\n",
+			"mode": "prepend",
+			"key": "m2"
+		},
+		{
+			"name": "messages",
+			"body": "",
+			"mode": "remove",
+			"key": "m1"
+		},
+		{
+			"name": "summary",
+			"body": "\n<div>total</div>\n",
+			"mode": "replace-outer"
+		}
+	]
+}`,
+	},
+
+	{
+		name: "adjust auto reload of portal",
+		eventFunc: func(ctx *EventContext) (r EventResponse, err error) {
+			r.NotModified = true
+			r.AutoReload = &AutoReload{Interval: 5 * time.Second}
+			return
+		},
+		expectedEventResp: `{
+	"body": "",
+	"pushState": null,
+	"notModified": true,
+	"autoReload": {
+		"interval": 5000
+	}
+}`,
+	},
+
+	{
+		name: "cache portal loader and invalidate caches",
+		eventFunc: func(ctx *EventContext) (r EventResponse, err error) {
+			r.Body = h.Div().Text("customer")
+			r.Cache = &ResponseCache{TTL: time.Minute, Key: "customer-1", VaryByQuery: []string{"lang"}}
+			InvalidateCaches(&r, "orders", "invoices")
+			return
+		},
+		expectedEventResp: `{
+	"body": "\n<div>customer</div>\n",
+	"pushState": null,
+	"cache": {
+		"ttl": 60000,
+		"key": "customer-1",
+		"varyByQuery": ["lang"]
+	},
+	"invalidateCaches": ["orders", "invoices"]
+}`,
+	},
+
+	{
+		name: "set states",
+		eventFunc: func(ctx *EventContext) (r EventResponse, err error) {
+			r.SetState(StateLocals, "user.name", `Felix "</script>"`)
+			r.SetState(StateVars, "", map[string]any{"count": 2, "tags": []string{"a"}})
+			r.SetState(StateDash, "items[0]", nil)
+			return
+		},
+		expectedEventResp: `{
+	"body": "",
+	"pushState": null,
+	"states": [
+		{"target": "locals", "path": "user.name", "value": "Felix \"\u003c/script\u003e\""},
+		{"target": "vars", "value": {"count": 2, "tags": ["a"]}},
+		{"target": "dash", "path": "items[0]", "value": null}
+	]
+}`,
+	},
+
 	{
 		name: "case 1",
 		renderChanger: func(ctx *EventContext, pr *PageResponse) {
@@ -2,28 +2,40 @@ package web
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	h "github.com/theplant/htmlgo"
 )
 
 type PortalBuilder struct {
-	tag *h.HTMLTagBuilder
+	tag                *h.HTMLTagBuilder
+	children           []h.HTMLComponent
+	loader             *VueEventTagBuilder
+	visible            string
+	loadWhenInViewport bool
 }
 
 func Portal(children ...h.HTMLComponent) (r *PortalBuilder) {
 	r = &PortalBuilder{
-		tag: h.Tag("go-plaid-portal").Children(children...),
+		tag:      h.Tag("go-plaid-portal"),
+		children: children,
 	}
 	r.Visible("true").Form("form").Locals("locals").Dash("dash")
 	return
 }
 
+// Loader loads the body of the portal when it is mounted and reloaded.
+// The pages of PageBuilder.InlinePortalLoaders render the body in the first render instead,
+// if the portal is visible and the loader has only the static arguments of the event func, queries and field values.
 func (b *PortalBuilder) Loader(v *VueEventTagBuilder) (r *PortalBuilder) {
+	b.loader = v
 	b.tag.SetAttr(":loader", v.String())
 	return b
 }
 
 func (b *PortalBuilder) Visible(v string) (r *PortalBuilder) {
+	b.visible = v
 	b.tag.Attr(":visible", v)
 	return b
 }
@@ -47,13 +59,17 @@ func (b *PortalBuilder) Dash(v string) (r *PortalBuilder) {
 	b.tag.Attr(":dash", v)
 	return b
 }
+
+// AutoReloadInterval reloads the portal in the interval of milliseconds while the document is visible,
+// the interval is doubled after each failed reload, and the server adjusts or stops it by EventResponse.AutoReload.
+// The responses of EventResponse.NotModified keep the body instead of rendering it again.
 func (b *PortalBuilder) AutoReloadInterval(v interface{}) (r *PortalBuilder) {
 	b.tag.Attr(":auto-reload-interval", v)
 	return b
 }
 
 func (b *PortalBuilder) Children(comps ...h.HTMLComponent) (r *PortalBuilder) {
-	b.tag.Children(comps...)
+	b.children = comps
 	return b
 }
 
@@ -62,11 +78,52 @@ func (b *PortalBuilder) LoadWhenParentVisible() (r *PortalBuilder) {
 	return b
 }
 
+// LoadWhenInViewport defers the loader until the portal is in the viewport grown by the rootMargin, e.g. "200px",
+// so that the portals below the fold are loaded when they are scrolled to.
+// The portals without height are all in the viewport, so a Placeholder with the height of the loaded body is expected.
+func (b *PortalBuilder) LoadWhenInViewport(rootMargin string) (r *PortalBuilder) {
+	b.loadWhenInViewport = true
+	b.tag.Attr(":load-when-in-viewport", "true")
+	if rootMargin != "" {
+		b.tag.Attr("root-margin", rootMargin)
+	}
+	return b
+}
+
+// Placeholder is shown until the body is loaded, e.g. a skeleton of the body
+func (b *PortalBuilder) Placeholder(comps ...h.HTMLComponent) (r *PortalBuilder) {
+	b.children = append(b.children, Slot(comps...).Name("placeholder"))
+	return b
+}
+
+// Fallback is shown instead of the body if the loader fails after the retries, e.g. the event responds 404 or 500,
+// so that only the portal is broken instead of the page. The slot has `error`, the message of the failure,
+// and `reload`, which loads the portal again, e.g. h.Button("Retry").Attr("@click", "reload()").
+func (b *PortalBuilder) Fallback(comps ...h.HTMLComponent) (r *PortalBuilder) {
+	b.children = append(b.children, Slot(comps...).Name("fallback").Scope("{ error, reload }"))
+	return b
+}
+
+// Retry loads the portal again at most n times if the loader fails,
+// it waits for the backoff before the first retry, and doubles the wait before each of the next ones.
+func (b *PortalBuilder) Retry(n int, backoff time.Duration) (r *PortalBuilder) {
+	b.tag.Attr(":retry", fmt.Sprint(n))
+	b.tag.Attr(":retry-backoff", fmt.Sprint(backoff.Milliseconds()))
+	return b
+}
+
 func (b *PortalBuilder) ParentForceUpdateAfterLoaded() (r *PortalBuilder) {
 	b.tag.Attr(":after-loaded", "parent.forceUpdate")
 	return b
 }
 
 func (b *PortalBuilder) MarshalHTML(ctx context.Context) (r []byte, err error) {
-	return b.tag.MarshalHTML(ctx)
+	children := b.children
+	if b.loader != nil && b.visible == "true" && !b.loadWhenInViewport {
+		if body, ok := inlineLoader(ctx, b.loader); ok {
+			// the portal shows the loaded slot instead of loading it when mounted
+			children = append(children[:len(children):len(children)], Slot(body).Name("loaded"))
+		}
+	}
+	return b.tag.Children(children...).MarshalHTML(ctx)
 }
@@ -0,0 +1,225 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	h "github.com/theplant/htmlgo"
+)
+
+// portalLoaderInliner returns the body of the loader rendered in the server, it returns false if the loader can't be inlined
+type portalLoaderInliner func(ctx context.Context, loader *VueEventTagBuilder) (body string, ok bool)
+
+// staticEvent is the event of a loader which can be posted without the browser
+type staticEvent struct {
+	id         string
+	query      url.Values
+	mergeQuery bool
+	fields     url.Values
+}
+
+// staticEvent returns the event the builder posts, it returns false if the builder depends on the browser,
+// e.g. the scripts or the arguments of web.Var
+func (b *VueEventTagBuilder) staticEvent() (ev *staticEvent, ok bool) {
+	if b.beforeScript != "" || b.afterScript != "" || b.thenScript != "" {
+		return nil, false
+	}
+	ev = &staticEvent{
+		query:  url.Values{},
+		fields: url.Values{},
+	}
+	for _, c := range b.calls {
+		switch c.method {
+		case "plaid", "vars", "locals", "form", "dash", "method":
+			// the form of the portal is empty in the first render
+		case "eventFunc":
+			if ev.id, ok = c.args[0].(string); !ok {
+				return nil, false
+			}
+		case "mergeQuery":
+			if ev.mergeQuery, ok = c.args[0].(bool); !ok {
+				return nil, false
+			}
+		case "query", "fieldValue":
+			key, ok := c.args[0].(string)
+			if !ok {
+				return nil, false
+			}
+			vs, ok := staticValues(c.args[1])
+			if !ok {
+				return nil, false
+			}
+			if c.method == "query" {
+				ev.query[key] = vs
+			} else {
+				ev.fields[key] = vs
+			}
+		default:
+			return nil, false
+		}
+	}
+	return ev, ev.id != ""
+}
+
+func staticValues(v interface{}) (vs []string, ok bool) {
+	switch v := v.(type) {
+	case Var:
+		return nil, false
+	case string:
+		return []string{v}, true
+	case []string:
+		return v, true
+	case bool, int, int64, float64:
+		return []string{fmt.Sprint(v)}, true
+	}
+	return nil, false
+}
+
+// request returns the request the browser posts for the event in the page of r
+func (ev *staticEvent) request(r *http.Request) (req *http.Request, err error) {
+	query := ev.query
+	// the queries replace the ones of the page unless they are merged
+	if len(ev.query) == 0 || ev.mergeQuery {
+		query = r.URL.Query()
+		for k, vs := range ev.query {
+			query[k] = vs
+		}
+	}
+	query.Set(EventFuncIDName, ev.id)
+
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	return eventRequest(r, &u, ev.fields)
+}
+
+// eventRequest returns the request of r posting the fields to u as the multipart form like the browser
+func eventRequest(r *http.Request, u *url.URL, fields url.Values) (req *http.Request, err error) {
+	body := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(body)
+	for k, vs := range fields {
+		for _, v := range vs {
+			if err = mw.WriteField(k, v); err != nil {
+				return
+			}
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return
+	}
+
+	req = r.Clone(r.Context())
+	req.Method = http.MethodPost
+	req.URL = u
+	req.RequestURI = u.RequestURI()
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.ContentLength = int64(body.Len())
+	req.Body = io.NopCloser(body)
+	req.Form, req.PostForm, req.MultipartForm = nil, nil, nil
+	return
+}
+
+// portalLoaderInliner runs the event funcs of the loaders with the page's injector,
+// the loaders of the unknown event funcs, the failed or panicked ones, the ones responding more than the body
+// and the ones writing the response directly are left to the browser.
+// The cookies set by the inlined loaders are added to the page's response, the other headers are dropped.
+func (p *PageBuilder) portalLoaderInliner(pageCtx *EventContext) portalLoaderInliner {
+	return func(ctx context.Context, loader *VueEventTagBuilder) (body string, ok bool) {
+		ev, ok := loader.staticEvent()
+		if !ok {
+			return "", false
+		}
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("failed to inline the loader of event %s: %v\n", ev.id, rec)
+				body, ok = "", false
+			}
+		}()
+		ef := p.lookupEventFunc(ev.id)
+		if ef == nil {
+			return "", false
+		}
+		req, err := ev.request(pageCtx.R.WithContext(ctx))
+		if err != nil {
+			log.Printf("failed to inline the loader of event %s: %v\n", ev.id, err)
+			return "", false
+		}
+
+		w := newCapturedResponseWriter()
+		evCtx := &EventContext{
+			R:        req,
+			W:        w,
+			Injector: pageCtx.Injector,
+		}
+		evCtx.withSelf()
+		er, err := ef(evCtx)
+		if err != nil {
+			log.Printf("failed to inline the loader of event %s: %v\n", ev.id, err)
+			return "", false
+		}
+		if er.Body == nil {
+			return "", false
+		}
+		if field := browserOnlyField(&er); field != "" {
+			log.Printf("the loader of event %s is not inlined since it responds %s\n", ev.id, field)
+			return "", false
+		}
+		b, err := er.Body.MarshalHTML(evCtx.R.Context())
+		if err != nil {
+			log.Printf("failed to inline the loader of event %s: %v\n", ev.id, err)
+			return "", false
+		}
+		if w.written {
+			log.Printf("the loader of event %s is not inlined since it writes the response\n", ev.id)
+			return "", false
+		}
+		w.copyCookiesTo(pageCtx.W)
+		return string(b), true
+	}
+}
+
+// browserOnlyField returns the field of the response which is applied by the browser besides the body,
+// the response of it can't be inlined since only the body is rendered in the page
+func browserOnlyField(er *EventResponse) string {
+	switch {
+	case er.PageTitle != "":
+		return "PageTitle"
+	case er.Reload:
+		return "Reload"
+	case er.PushState != nil:
+		return "PushState"
+	case er.RedirectURL != "":
+		return "RedirectURL"
+	case len(er.ReloadPortals) > 0:
+		return "ReloadPortals"
+	case len(er.UpdatePortals) > 0:
+		return "UpdatePortals"
+	case er.RunScript != "":
+		return "RunScript"
+	case er.AutoReload != nil:
+		return "AutoReload"
+	case len(er.InvalidateCaches) > 0:
+		return "InvalidateCaches"
+	case len(er.States) > 0:
+		return "States"
+	}
+	return ""
+}
+
+// inlineLoader returns the body of the loader if the page inlines the portal loaders
+func inlineLoader(ctx context.Context, loader *VueEventTagBuilder) (body h.HTMLComponent, ok bool) {
+	inline, ok := ctx.Value(portalLoaderInlinerKey).(portalLoaderInliner)
+	if !ok {
+		return nil, false
+	}
+	s, ok := inline(ctx, loader)
+	if !ok {
+		return nil, false
+	}
+	return h.RawHTML(s), true
+}
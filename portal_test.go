@@ -0,0 +1,170 @@
+package web_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	h "github.com/theplant/htmlgo"
+	"github.com/theplant/testingutils"
+
+	. "github.com/qor5/web/v3"
+)
+
+func TestPortalLoadWhenInViewport(t *testing.T) {
+	portal := Portal().
+		Name("chart").
+		Loader(Plaid().EventFunc("loadChart")).
+		LoadWhenInViewport("200px").
+		Placeholder(h.Div().Class("skeleton"))
+
+	expected := `
+<go-plaid-portal :visible='true' :form='form' :locals='locals' :dash='dash' portal-name='chart' :loader='plaid().vars(vars).locals(locals).form(form).dash(dash).eventFunc("loadChart")' :load-when-in-viewport='true' root-margin='200px'>
+<template v-slot:placeholder>
+<div class='skeleton'></div>
+</template>
+</go-plaid-portal>
+`
+	diff := testingutils.PrettyJsonDiff(expected, h.MustString(portal, context.TODO()))
+	if diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestPageInlinePortalLoaders(t *testing.T) {
+	pb := New()
+	p := pb.Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		pr.Body = h.Components(
+			Portal().Name("chart").Loader(Plaid().EventFunc("loadChart").Query("range", "week").FieldValue("type", "bar")),
+			Portal().Name("lazy").Loader(Plaid().EventFunc("loadChart")).LoadWhenInViewport(""),
+			Portal().Name("dynamic").Loader(Plaid().EventFunc("loadChart").Query("range", Var("locals.range"))),
+		)
+		return
+	}).EventFunc("loadChart", func(ctx *EventContext) (r EventResponse, err error) {
+		r.Body = h.Div().Text(ctx.R.FormValue("range") + ":" + ctx.R.FormValue("type") + ":" + ctx.R.FormValue("id"))
+		return
+	}).InlinePortalLoaders(true)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/chart?id=1", nil))
+	body := w.Body.String()
+
+	if !strings.Contains(body, `portal-name='chart' :loader='plaid().vars(vars).locals(locals).form(form).dash(dash).eventFunc("loadChart").query("range", "week").fieldValue("type", "bar")'>
+<template v-slot:loaded>
+<div>week:bar:</div>
+</template>
+</go-plaid-portal>`) {
+		t.Error("the loader of chart is not inlined", body)
+	}
+	if strings.Count(body, "v-slot:loaded") != 1 {
+		t.Error("only the visible portals of the static loaders are inlined", body)
+	}
+
+	// the queries of the page are kept without the queries of the loader
+	p = pb.Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		pr.Body = Portal().Loader(Plaid().EventFunc("loadChart"))
+		return
+	}).EventFunc("loadChart", func(ctx *EventContext) (r EventResponse, err error) {
+		r.Body = h.Div().Text(ctx.R.FormValue("id") + ":" + ctx.R.URL.Query().Get(EventFuncIDName))
+		return
+	}).InlinePortalLoaders(true)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/chart?id=1", nil))
+	if !strings.Contains(w.Body.String(), `<div>1:loadChart</div>`) {
+		t.Error("the queries of the page are not kept", w.Body.String())
+	}
+}
+
+func TestPageInlinePortalLoadersFallBackToBrowser(t *testing.T) {
+	pb := New()
+	p := pb.Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		pr.Body = h.Components(
+			Portal().Name("scripted").Loader(Plaid().EventFunc("loadScripted")),
+			Portal().Name("panicked").Loader(Plaid().EventFunc("loadPanicked")),
+			Portal().Name("plain").Loader(Plaid().EventFunc("loadPlain")),
+			Portal().Name("written").Loader(Plaid().EventFunc("loadWritten")),
+		)
+		return
+	}).EventFunc("loadScripted", func(ctx *EventContext) (r EventResponse, err error) {
+		r.Body = h.Div().Text("scripted")
+		r.RunScript = "vars.loaded = true"
+		return
+	}).EventFunc("loadPanicked", func(ctx *EventContext) (r EventResponse, err error) {
+		panic("failed")
+	}).EventFunc("loadPlain", func(ctx *EventContext) (r EventResponse, err error) {
+		ctx.W.Header().Set("Cache-Control", "no-store")
+		http.SetCookie(ctx.W, &http.Cookie{Name: "session", Value: "refreshed"})
+		r.Body = h.Div().Text("plain")
+		return
+	}).EventFunc("loadWritten", func(ctx *EventContext) (r EventResponse, err error) {
+		ctx.W.WriteHeader(http.StatusAccepted)
+		r.Body = h.Div().Text("written")
+		return
+	}).InlinePortalLoaders(true)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+
+	if w.Code != http.StatusOK {
+		t.Error("the panicked loader fails the page", w.Code)
+	}
+	if strings.Contains(body, "<div>scripted</div>") {
+		t.Error("the loader responding the run script is inlined", body)
+	}
+	if strings.Contains(body, "<div>written</div>") {
+		t.Error("the loader writing the response is inlined", body)
+	}
+	if strings.Count(body, "v-slot:loaded") != 1 || !strings.Contains(body, "<div>plain</div>") {
+		t.Error("only the loader responding the body is inlined", body)
+	}
+	if w.Header().Get("Cache-Control") != "" || w.Header().Get("Set-Cookie") != "session=refreshed" {
+		t.Error("only the cookies of the inlined loader are kept", w.Header())
+	}
+}
+
+func TestPortalFallbackAndRetry(t *testing.T) {
+	portal := Portal().
+		Name("chart").
+		Loader(Plaid().EventFunc("loadChart")).
+		Retry(3, 500*time.Millisecond).
+		Fallback(h.Div(h.Text("{{ error }}"), h.Button("Retry").Attr("@click", "reload()")))
+
+	expected := `
+<go-plaid-portal :visible='true' :form='form' :locals='locals' :dash='dash' portal-name='chart' :loader='plaid().vars(vars).locals(locals).form(form).dash(dash).eventFunc("loadChart")' :retry='3' :retry-backoff='500'>
+<template v-slot:fallback='{ error, reload }'>
+<div>{{ error }}
+<button @click='reload()'>Retry</button>
+</div>
+</template>
+</go-plaid-portal>
+`
+	diff := testingutils.PrettyJsonDiff(expected, h.MustString(portal, context.TODO()))
+	if diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestPortalLoaderErrorStatus(t *testing.T) {
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		return
+	}).EventFunc("loadChart", func(ctx *EventContext) (r EventResponse, err error) {
+		return r, errors.New("database is down")
+	})
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/?__execute_event__=loadChart&"+LoadPortalBodyName+"=1", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the status of the failed loader, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/?__execute_event__=missing&"+LoadPortalBodyName+"=1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the status of the missing loader, got %d", w.Code)
+	}
+}
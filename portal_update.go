@@ -0,0 +1,82 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	h "github.com/theplant/htmlgo"
+)
+
+type ScrollBehavior string
+
+const (
+	ScrollBehaviorAuto    ScrollBehavior = "auto"
+	ScrollBehaviorSmooth  ScrollBehavior = "smooth"
+	ScrollBehaviorInstant ScrollBehavior = "instant"
+)
+
+type ScrollLogicalPosition string
+
+const (
+	ScrollPositionStart   ScrollLogicalPosition = "start"
+	ScrollPositionCenter  ScrollLogicalPosition = "center"
+	ScrollPositionEnd     ScrollLogicalPosition = "end"
+	ScrollPositionNearest ScrollLogicalPosition = "nearest"
+)
+
+// ScrollIntoViewOptions is the options of Element.scrollIntoView, the empty ones are the defaults of the browser
+type ScrollIntoViewOptions struct {
+	Behavior ScrollBehavior        `json:"behavior,omitempty"`
+	Block    ScrollLogicalPosition `json:"block,omitempty"`
+	Inline   ScrollLogicalPosition `json:"inline,omitempty"`
+}
+
+// PortalAfterLoadedBuilder builds the script which runs after the body of a PortalUpdate is rendered,
+// the script is called with the portal element as `el`, and `vars`, `locals`, `form`, `dash` and `plaid` of the portal.
+// The selectors are matched within the portal, and the portal itself is the target of the empty selector.
+type PortalAfterLoadedBuilder struct {
+	scripts []string
+}
+
+func PortalAfterLoaded() (r *PortalAfterLoadedBuilder) {
+	return &PortalAfterLoadedBuilder{}
+}
+
+func (b *PortalAfterLoadedBuilder) target(selector string, script string) (r *PortalAfterLoadedBuilder) {
+	query := "el"
+	if selector != "" {
+		query = fmt.Sprintf("el.querySelector(%s)", h.JSONString(selector))
+	}
+	b.scripts = append(b.scripts, fmt.Sprintf("{ const target = %s; if (target) { %s } }", query, script))
+	return b
+}
+
+// Focus focuses the first element matching the selector, e.g. the first input of a form
+func (b *PortalAfterLoadedBuilder) Focus(selector string) (r *PortalAfterLoadedBuilder) {
+	return b.target(selector, "target.focus();")
+}
+
+// ScrollIntoView scrolls the first element matching the selector into view, e.g. the new item appended to a list
+func (b *PortalAfterLoadedBuilder) ScrollIntoView(selector string, opts ScrollIntoViewOptions) (r *PortalAfterLoadedBuilder) {
+	return b.target(selector, fmt.Sprintf("target.scrollIntoView(%s);", h.JSONString(opts)))
+}
+
+// Event posts the event after loaded, e.g. web.POST().EventFunc("markAsRead")
+func (b *PortalAfterLoadedBuilder) Event(v *VueEventTagBuilder) (r *PortalAfterLoadedBuilder) {
+	return b.Script(v.Go())
+}
+
+// Script runs the raw script
+func (b *PortalAfterLoadedBuilder) Script(script string) (r *PortalAfterLoadedBuilder) {
+	b.scripts = append(b.scripts, script)
+	return b
+}
+
+func (b *PortalAfterLoadedBuilder) String() string {
+	return strings.Join(b.scripts, "; ")
+}
+
+func (b *PortalAfterLoadedBuilder) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
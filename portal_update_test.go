@@ -0,0 +1,48 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	h "github.com/theplant/htmlgo"
+
+	. "github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+)
+
+func TestPortalAfterLoaded(t *testing.T) {
+	p := New().Page(func(ctx *EventContext) (pr PageResponse, err error) {
+		return
+	}).EventFunc("call", func(ctx *EventContext) (r EventResponse, err error) {
+		r.UpdatePortals = append(r.UpdatePortals,
+			&PortalUpdate{
+				Name: "form",
+				Body: h.Input("title"),
+				AfterLoaded: PortalAfterLoaded().
+					Focus("input[name=title]").
+					ScrollIntoView("", ScrollIntoViewOptions{Behavior: ScrollBehaviorSmooth, Block: ScrollPositionNearest}).
+					Event(Plaid().EventFunc("loaded")),
+			},
+			&PortalUpdate{Name: "other", Body: h.Text("other")},
+		)
+		return
+	})
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, multipartestutils.NewMultipartBuilder().EventFunc("call").BuildEventFuncRequest())
+
+	var er multipartestutils.TestEventResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &er); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{ const target = el.querySelector("input[name=title]"); if (target) { target.focus(); } }; ` +
+		`{ const target = el; if (target) { target.scrollIntoView({"behavior":"smooth","block":"nearest"}); } }; ` +
+		`plaid().vars(vars).locals(locals).form(form).dash(dash).eventFunc("loaded").go()`
+	if er.UpdatePortals[0].AfterLoaded != expected {
+		t.Errorf("expected %q, got %q", expected, er.UpdatePortals[0].AfterLoaded)
+	}
+	if er.UpdatePortals[1].AfterLoaded != "" {
+		t.Errorf("expected no after loaded script, got %q", er.UpdatePortals[1].AfterLoaded)
+	}
+}
@@ -30,12 +30,7 @@ func (b *ScopeBuilder) init(attr string, vs ...interface{}) (r *ScopeBuilder) {
 	}
 	js := make([]string, 0)
 	for _, v := range vs {
-		switch vt := v.(type) {
-		case string:
-			js = append(js, vt)
-		default:
-			js = append(js, h.JSONString(v))
-		}
+		js = append(js, jsScript(v))
 	}
 	initVal := js[0]
 	if len(js) > 1 {
@@ -65,6 +60,18 @@ func (b *ScopeBuilder) OnChange(v string) (r *ScopeBuilder) {
 	return b
 }
 
+// OnChangeJS is OnChange of the script built by JS
+func (b *ScopeBuilder) OnChangeJS(v Var) (r *ScopeBuilder) {
+	return b.OnChange(string(v))
+}
+
+// ExposeAs exposes the locals at the path in vars while the scope is mounted,
+// e.g. ExposeAs("compos", "list:0") makes them accessible as vars.compos["list:0"] outside the scope.
+func (b *ScopeBuilder) ExposeAs(path ...string) (r *ScopeBuilder) {
+	b.tag.Attr(":expose-as", h.JSONString(path))
+	return b
+}
+
 func (b *ScopeBuilder) UseDebounce(v int) (r *ScopeBuilder) {
 	b.tag.Attr(":use-debounce", v)
 	return b
@@ -3,6 +3,7 @@ package stateful
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"reflect"
@@ -15,106 +16,274 @@ import (
 )
 
 func Install(b web.EventFuncHub, dc *DependencyCenter) {
-	b.RegisterEventFunc(eventDispatchAction, newEventDispatchActionHandler(dc))
+	b.RegisterEventFunc(EventDispatchAction, newEventDispatchActionHandler(dc))
 }
 
 type Action struct {
 	CompoType string          `json:"compo_type"`
-	Compo     json.RawMessage `json:"compo"`
-	Injector  string          `json:"injector"`
-	SyncQuery bool            `json:"sync_query"`
-	Method    string          `json:"method"`
-	Request   json.RawMessage `json:"request"`
+	CompoID   string          `json:"compo_id,omitempty"`
+	Compo     json.RawMessage `json:"compo,omitempty"`
+	Injector  string          `json:"injector,omitempty"`
+	SyncQuery bool            `json:"sync_query,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Request   json.RawMessage `json:"request,omitempty"`
+
+	// Ancestors are the ancestor compos which handle the events emitted by the action, the nearest first.
+	// They are rendered with the ids only, the browser posts them with the live states read from varsKeyCompos.
+	Ancestors []Action `json:"ancestors,omitempty"`
+	// StoredQueries are the raw queries of the store tags keyed by the store name
+	StoredQueries map[string]string `json:"stored_queries,omitempty"`
+	// Version is the version of the Versioned compo when it was rendered or patched, it is kept in the locals
+	Version string `json:"version,omitempty"`
+	// RestoredQuery is the raw query of the fields restored from the client stores on mount, see ClientQueryStore
+	RestoredQuery string `json:"restored_query,omitempty"`
+	// QueryDefaults are the initial values of the synced query fields which differ from their default tags
+	// in the query format keyed by the query names, e.g. set by the caller of SyncQuery.
+	// The browser leaves the fields equal to them out of the url, since they are restored when the query is absent.
+	QueryDefaults map[string]string `json:"query_defaults,omitempty"`
 }
 
+// renderedLocals are the data in the locals of the compo rendered by Actionable, see parseRenderedCompos
+type renderedLocals struct {
+	Compo      json.RawMessage `json:"compo"`             // LocalsKeyCompo
+	Version    string          `json:"version,omitempty"` // LocalsKeyVersion
+	ActionBase Action          `json:"actionBase"`        // LocalsKeyActionBase
+}
+
+// goplaidKeyCompoTypes is the registry of the compo types in window.__goplaid, it is injected once per page by web.InjectScript
+const goplaidKeyCompoTypes = "statefulCompoTypes"
+
+// varsKeyCompos is the registry of the locals of the Identifiable compos mounted in the page, keyed by the compo id
+const varsKeyCompos = "__statefulCompos"
+
 const (
-	LocalsKeyNewAction   = "newAction"
-	LocalsKeyQueryTags   = "queryTags"
-	LocalsKeySetCookies  = "setCookies"
-	LocalsKeyEncodeQuery = "encodeQuery"
+	LocalsKeyCompo        = "compo"
+	LocalsKeyNewAction    = "newAction"
+	LocalsKeyQueryTags    = "queryTags"
+	LocalsKeyStoreQueries = "storeQueries"
+	LocalsKeyEncodeQuery  = "encodeQuery"
+	LocalsKeyVersion      = "version"
+	LocalsKeyActionBase   = "actionBase"
+
+	// Deprecated: use LocalsKeyStoreQueries instead, it is kept as an alias of it in the locals.
+	LocalsKeySetCookies = "setCookies"
 )
 
 func Actionable[T h.HTMLComponent](ctx context.Context, c T, children ...h.HTMLComponent) (r h.HTMLComponent) {
+	if hook, ok := any(c).(BeforeRenderHook); ok {
+		if err := hook.BeforeRender(ctx); err != nil {
+			// returned by the MarshalHTML of the compo which renders the actionable
+			return h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+				return nil, fmt.Errorf("failed to render compo %T: %w", c, err)
+			})
+		}
+	}
+
 	defer func() {
 		if ident, ok := any(c).(Identifiable); ok {
 			r = reloadable(ident, r)
 		}
 	}()
-	actionBase := PrettyJSONString(Action{
+	ident, identifiable := any(c).(Identifiable)
+	base := Action{
 		CompoType: fmt.Sprintf("%T", c),
-		Compo:     json.RawMessage(PrettyJSONString(c)),
+		Compo:     json.RawMessage(h.JSONString(c)),
 		Injector:  injectorNameFromContext(ctx),
 		SyncQuery: IsSyncQuery(ctx),
-		Method:    "",
-		Request:   json.RawMessage("{}"),
+		Version:   compoVersion(c),
+	}
+	base.QueryDefaults = syncQueryDefaults(ctx, base.CompoType)
+	if identifiable {
+		base.CompoID = ident.CompoID()
+	}
+	ancestors := actionAncestorsFromContext(ctx)
+	if _, ok := any(c).(ActionEventHandler); ok {
+		if !identifiable {
+			panic(fmt.Errorf("compo %T implements ActionEventHandler but not Identifiable", c))
+		}
+		// the descendants carry the id of this compo, its live state is read from varsKeyCompos when they post actions
+		inner, descendantAncestors := children, append([]Action{actionAncestor(base)}, ancestors...)
+		children = []h.HTMLComponent{h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+			return h.Components(inner...).MarshalHTML(withActionAncestors(ctx, descendantAncestors))
+		})}
+	}
+	base.Ancestors = lo.Map(ancestors, func(ancestor Action, _ int) Action {
+		return actionAncestor(ancestor)
 	})
+	data := renderedLocals{Compo: base.Compo, Version: base.Version}
+	// the state and the version live in the reactive locals, so they can be patched by AppendPatchToResponse
+	base.Compo, base.Version = nil, ""
+	data.ActionBase = base
 	queryTags, err := ParseQueryTags(c)
 	if err != nil {
 		panic(err)
 	}
 
-	queryEncoders := lo.Map(QueryTagMethods, func(method *QueryTagMethod, _ int) string {
-		return fmt.Sprintf(`__queryEncoder_%s__: %s`, method.Name, method.Encoder)
-	})
-	queryEncodersJs := ""
-	if len(queryEncoders) > 0 {
-		queryEncodersJs = strings.Join(queryEncoders, ",\n") + ",\n"
+	queryTagsJs := "function() { return []; }"
+	registerJs := ""
+	if len(queryTags) > 0 {
+		// the query tags and encoders are the same for the compos of a type, so they are registered once per page by type,
+		// out of the compos, since any of them may never be mounted, e.g. in a closed dialog
+		methodNames := lo.Uniq(lo.FilterMap(queryTags, func(tag QueryTag, _ int) (string, bool) {
+			return tag.Method, tag.Method != ""
+		}))
+		queryEncoders := lo.Map(methodNames, func(name string, _ int) string {
+			method, ok := LookupQueryTagMethod(name)
+			if !ok {
+				panic(fmt.Errorf("query tag method %q not registered", name))
+			}
+			return fmt.Sprintf("%s: %s,\n", h.JSONString(method.Name), method.Encoder)
+		})
+		compoTypeJs := h.JSONString(base.CompoType)
+		queryTagsJs = fmt.Sprintf(`function() { return vars.__window.__goplaid.%s[%s].queryTags(this.%s.query_defaults); }`,
+			goplaidKeyCompoTypes, compoTypeJs, LocalsKeyActionBase)
+		registerJs = fmt.Sprintf(`(function(types) {
+	types[%s] = types[%s] || {
+		queryTags: function(defaults) {
+			const encoders = {
+%s};
+			return %s.map(tag => Object.assign({}, tag,
+				tag.method ? { encoder: encoders[tag.method] } : {},
+				defaults && tag.name in defaults ? { default: defaults[tag.name] } : {}));
+		},
+	};
+})((window.__goplaid = window.__goplaid || {}).%s = window.__goplaid.%s || {})`,
+			compoTypeJs, compoTypeJs,
+			strings.Join(queryEncoders, ""),
+			h.JSONString(queryTags),
+			goplaidKeyCompoTypes, goplaidKeyCompoTypes,
+		)
 	}
 
-	ident, ok := any(c).(Identifiable)
-	if ok {
+	if storeNames := queryTags.StoreNames(); identifiable && len(storeNames) > 0 {
+		stores := lo.Map(storeNames, func(name string, _ int) string {
+			store, ok := LookupQueryStore(name)
+			if !ok {
+				panic(fmt.Errorf("query store %q not registered", name))
+			}
+			client := "null"
+			if cs, ok := store.(ClientQueryStore); ok {
+				client = cs.ClientScript()
+			}
+			return fmt.Sprintf(`{ name: %q, client: %s }`, name, client)
+		})
 		children = append([]h.HTMLComponent{
-			// borrow to get the document
-			h.Div().Attr("v-on-mounted", fmt.Sprintf(`({el}) => {
-	const cookieTags = locals.%s().filter(tag => tag.cookie)
+			h.Div().Attr("v-on-mounted", fmt.Sprintf(`({ window }) => {
+	const key = %q;
+	const stores = [%s];
+	const tags = locals.%s();
 	locals.%s = function(v) {
-		if (!v.sync_query || !el.ownerDocument) {
+		// the reload of the restored fields keeps the stores, the compo does not have the fields yet
+		if (!v.sync_query || v.restored_query) {
+			return;
+		}
+		v.stored_queries = {};
+		stores.forEach(store => {
+			const query = plaid().encodeObjectToQuery(v.compo, tags.filter(tag => tag.store === store.name));
+			if (store.client) {
+				store.client.save(key, query);
+			} else {
+				v.stored_queries[store.name] = query;
+			}
+		});
+	}
+
+	// the server can not load the client stores, so the stored fields missing in the url are restored to the url
+	if (!locals.%s().sync_query) {
+		return;
+	}
+	const matches = (k, name) => k === name || k.startsWith(name + "[");
+	const params = Array.from(new URLSearchParams(window.location.search).keys());
+	const restores = [];
+	stores.forEach(store => {
+		const stored = store.client && store.client.load(key);
+		if (!stored) {
 			return;
 		}
-		el.ownerDocument.cookie = "%s=" + plaid().encodeObjectToQuery(v.compo, cookieTags);
+		const names = tags.filter(tag => tag.store === store.name && !params.some(k => matches(k, tag.name))).map(tag => tag.name);
+		stored.split("&").forEach(pair => {
+			const k = decodeURIComponent(pair.split("=")[0]);
+			if (names.some(name => matches(k, name))) {
+				restores.push(pair);
+			}
+		});
+	});
+	if (restores.length > 0) {
+		// restored in place, the url is updated without navigating and the compo is reloaded with the restored fields
+		const restored = restores.join("&");
+		const search = window.location.search ? window.location.search + "&" : "?";
+		window.history.replaceState(window.history.state, "", window.location.pathname + search + restored + window.location.hash);
+		%s;
 	}
 }`,
+				IdentifiableCookieKey(ident),
+				strings.Join(stores, ", "),
 				LocalsKeyQueryTags,
-				LocalsKeySetCookies, IdentifiableCookieKey(ident),
+				LocalsKeyStoreQueries,
+				LocalsKeyNewAction,
+				PostAction(ctx, c, actionMethodReload, struct{}{}, WithAppendFix("v.restored_query = restored;")).
+					PushState(false).
+					Go(),
 			)),
 		}, children...)
 	}
 
-	return web.Scope(children...).
-		VSlot(fmt.Sprintf("{ locals }")).
-		Init(fmt.Sprintf(`{
-	%s
+	newVersionJs := ""
+	if data.Version != "" {
+		newVersionJs = fmt.Sprintf("\n\t\tv.version = this.%s;", LocalsKeyVersion)
+	}
+	ancestorsJs := ""
+	if len(base.Ancestors) > 0 {
+		ancestorsJs = fmt.Sprintf(`
+		v.ancestors.forEach(ancestor => {
+			const locals = (vars.%s || {})[ancestor.compo_id];
+			ancestor.compo = locals ? JSON.parse(JSON.stringify(locals.%s)) : null;
+		});`, varsKeyCompos, LocalsKeyCompo)
+	}
+
+	locals := fmt.Sprintf(`{
 	%s: function() {
-		return %s;
+		const v = JSON.parse(JSON.stringify(this.%s));
+		v.compo = JSON.parse(JSON.stringify(this.%s));%s%s
+		return v;
 	},
+	%s: %s,
+	%s: function(v) {}, // a placeholder
 	%s: function(v) {
-		let tags = %s || [];
-		tags.forEach(tag => {
-			if (tag.method) {
-				tag.encoder = this["__queryEncoder_" + tag.method + "__"];
-			}
-		});
-		return tags;
+		return this.%s(v);
 	},
-	%s: function(v) {}, // a placeholder
 	%s: function(v) {
-		if (!v.sync_query) {
-			return "";
-		}
-		return plaid().encodeObjectToQuery(v.compo, this.%s());
+		return v.sync_query ? plaid().encodeObjectToQuery(v.compo, this.%s()) : "";
 	},
 }`,
-			queryEncodersJs,
-			LocalsKeyNewAction, actionBase,
-			LocalsKeyQueryTags, PrettyJSONString(queryTags),
-			LocalsKeySetCookies,
-			LocalsKeyEncodeQuery, LocalsKeyQueryTags))
+		LocalsKeyNewAction, LocalsKeyActionBase, LocalsKeyCompo, newVersionJs, ancestorsJs,
+		LocalsKeyQueryTags, queryTagsJs,
+		LocalsKeyStoreQueries,
+		LocalsKeySetCookies, LocalsKeyStoreQueries,
+		LocalsKeyEncodeQuery, LocalsKeyQueryTags,
+	)
+	scope := web.Scope(children...).VSlot("{ locals }")
+	if identifiable {
+		// the reactive locals are registered by the compo id, so the actions of the descendants can read the live state
+		// and AppendPatchToResponse can patch it from the responses of any action
+		scope.ExposeAs(varsKeyCompos, base.CompoID)
+	}
+	// the data is rendered as json before the functions, so the rendered compos can be parsed from the html
+	scope.Init(data, locals)
+	if registerJs == "" {
+		return scope
+	}
+	return h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+		web.InjectScript(ctx, "stateful:compoType:"+base.CompoType, registerJs)
+		return scope.MarshalHTML(ctx)
+	})
 }
 
-const eventDispatchAction = "__dispatch_stateful_action__"
+// EventDispatchAction is the event func of the actions posted by PostAction, the action is posted in the FieldKeyAction field
+const EventDispatchAction = "__dispatch_stateful_action__"
 
 const (
-	fieldKeyAction = "__action__"
+	FieldKeyAction = "__action__"
 )
 
 type postActionOptions struct {
@@ -158,7 +327,7 @@ func postAction(ctx context.Context, c any, method any, request any, o *postActi
 	}
 
 	b := web.POST().
-		EventFunc(eventDispatchAction).
+		EventFunc(EventDispatchAction).
 		Queries(url.Values{}) // force clear queries first
 
 	evCtx := web.MustGetEventContext(ctx)
@@ -187,14 +356,14 @@ func postAction(ctx context.Context, c any, method any, request any, o *postActi
 		PrettyJSONString(request),
 		fix,
 		LocalsKeyEncodeQuery,
-		LocalsKeySetCookies,
+		LocalsKeyStoreQueries,
 	)))
 	b.StringQuery(web.Var(`(b) => b.__stringQuery__`))
 	b.PushState(web.Var(`(b) => b.__action__.sync_query`))
 	return b.BeforeFetch(fmt.Sprintf(`({b, url, opts}) => {
 		opts.body.set(%q, JSON.stringify(b.__action__, null, "\t")); 
 		return [url, opts];
-	}`, fieldKeyAction))
+	}`, FieldKeyAction))
 }
 
 var (
@@ -206,82 +375,136 @@ var (
 func newEventDispatchActionHandler(dc *DependencyCenter) web.EventFunc {
 	return func(evCtx *web.EventContext) (r web.EventResponse, err error) {
 		var action Action
-		if err = json.Unmarshal([]byte(evCtx.R.FormValue(fieldKeyAction)), &action); err != nil {
+		if err = json.Unmarshal([]byte(evCtx.R.FormValue(FieldKeyAction)), &action); err != nil {
 			return r, fmt.Errorf("failed to unmarshal action: %w", err)
 		}
 
-		v, err := newActionableCompo(action.CompoType)
+		// the scope is created before the contexts of the action are derived, so the action and the reload share it
+		requestScope(evCtx.R.Context())
+		v, ctx, err := restoreActionCompo(evCtx.R.Context(), dc, &action)
 		if err != nil {
 			return r, err
 		}
+		ctx, events := withActionEvents(withDispatchedCompo(withActionVersion(ctx, v, &action), v, action.Compo))
+		evCtx.R = evCtx.R.WithContext(ctx)
 
-		err = json.Unmarshal(action.Compo, v)
-		if err != nil {
-			return r, err
+		if action.SyncQuery {
+			if err := restoreClientQuery(v, action.RestoredQuery); err != nil {
+				return r, err
+			}
 		}
 
-		if action.Injector != "" {
-			evCtx.R = evCtx.R.WithContext(withInjectorName(evCtx.R.Context(), action.Injector))
-			if err := dc.Apply(evCtx.R.Context(), v); err != nil {
-				return r, err
+		if hook, ok := v.(BeforeActionHook); ok {
+			if err := hook.BeforeAction(ctx, action.Method); err != nil {
+				return r, fmt.Errorf("action method %q rejected: %w", action.Method, err)
 			}
 		}
 
+		// the rejected request neither stores the queries nor learns the current state from the conflict
 		if action.SyncQuery {
-			evCtx.R = evCtx.R.WithContext(withSyncQuery(evCtx.R.Context()))
+			if err := saveStoredQueries(evCtx, v, action.StoredQueries); err != nil {
+				return r, err
+			}
 		}
 
-		method := reflect.ValueOf(v).MethodByName(action.Method)
-		if method.IsValid() && method.Kind() == reflect.Func {
-			methodType := method.Type()
-			if methodType.NumOut() != 2 ||
-				methodType.Out(0) != outType0 ||
-				methodType.Out(1) != outType1 {
-				return r, fmt.Errorf("action method %q has incorrect signature", action.Method)
-			}
+		conflict, err := checkActionVersion(ctx, v, &action)
+		if err != nil {
+			return r, err
+		}
+		if conflict != nil {
+			return *conflict, nil
+		}
 
-			numIn := methodType.NumIn()
-			if numIn <= 0 || numIn > 2 {
-				return r, fmt.Errorf("action method %q has incorrect number of arguments", action.Method)
-			}
-			if methodType.In(0) != inType0 {
-				return r, fmt.Errorf("action method %q has incorrect signature", action.Method)
-			}
-			ctxValue := reflect.ValueOf(evCtx.R.Context())
-
-			params := []reflect.Value{ctxValue}
-			if numIn == 2 {
-				argType := methodType.In(1)
-				argValue := reflect.New(argType).Interface()
-				err := json.Unmarshal([]byte(action.Request), &argValue)
-				if err != nil {
-					return r, fmt.Errorf("failed to unmarshal action request to %T: %w", argValue, err)
-				}
-				params = append(params, reflect.ValueOf(argValue).Elem())
+		if isHistoryMethod(v, action.Method) {
+			r, err = travelHistory(ctx, dc, v, &action)
+		} else {
+			r, err = callActionMethod(ctx, v, &action)
+		}
+		var rverr *requestValidationError
+		if errors.As(err, &rverr) {
+			return onRequestValidationFailed(ctx, v, action.Method, rverr.verrs)
+		}
+		if cr, ok, cerr := onActionVersionConflict(ctx, v, &action, err); ok {
+			return cr, cerr
+		}
+		if err != nil {
+			return r, err
+		}
+		if hook, ok := v.(AfterActionHook); ok {
+			hook.AfterAction(ctx, &r)
+		}
+		if err := appendVersionToResponse(ctx, &r, v, &action); err != nil {
+			return r, err
+		}
+		if !isHistoryMethod(v, action.Method) {
+			if err := recordHistory(ctx, v, &action); err != nil {
+				return r, fmt.Errorf("failed to record history of compo %T: %w", v, err)
 			}
+		}
+
+		ar, err := bubbleActionEvents(ctx, dc, action.Ancestors, events.events)
+		if err != nil {
+			return r, err
+		}
+		mergeEventResponse(&r, ar)
+		return r, nil
+	}
+}
+
+func callActionMethod(ctx context.Context, v h.HTMLComponent, action *Action) (r web.EventResponse, err error) {
+	method := reflect.ValueOf(v).MethodByName(action.Method)
+	if method.IsValid() && method.Kind() == reflect.Func {
+		methodType := method.Type()
+		if methodType.NumOut() != 2 ||
+			methodType.Out(0) != outType0 ||
+			methodType.Out(1) != outType1 {
+			return r, fmt.Errorf("action method %q has incorrect signature", action.Method)
+		}
 
-			result := method.Call(params)
-			if len(result) != 2 || !result[0].CanInterface() || !result[1].CanInterface() {
-				return r, fmt.Errorf("action method %q has incorrect return values", action.Method)
+		numIn := methodType.NumIn()
+		if numIn <= 0 || numIn > 2 {
+			return r, fmt.Errorf("action method %q has incorrect number of arguments", action.Method)
+		}
+		if methodType.In(0) != inType0 {
+			return r, fmt.Errorf("action method %q has incorrect signature", action.Method)
+		}
+		ctxValue := reflect.ValueOf(ctx)
+
+		params := []reflect.Value{ctxValue}
+		if numIn == 2 {
+			argType := methodType.In(1)
+			argValue := reflect.New(argType).Interface()
+			err := json.Unmarshal([]byte(action.Request), &argValue)
+			if err != nil {
+				return r, fmt.Errorf("failed to unmarshal action request to %T: %w", argValue, err)
 			}
-			r = result[0].Interface().(web.EventResponse)
-			if result[1].IsNil() {
-				return r, nil
+			if err := validateActionRequest(argValue); err != nil {
+				return r, err
 			}
-			err = result[1].Interface().(error)
-			return r, fmt.Errorf("failed to call action method %q: %w", action.Method, err)
+			params = append(params, reflect.ValueOf(argValue).Elem())
 		}
 
-		switch action.Method {
-		case actionMethodReload:
-			rc, ok := v.(Identifiable)
-			if !ok {
-				return r, fmt.Errorf("compo %T does not implement Identifiable", v)
-			}
-			return OnReload(rc)
-		default:
-			return r, fmt.Errorf("action method %q not found", action.Method)
+		result := method.Call(params)
+		if len(result) != 2 || !result[0].CanInterface() || !result[1].CanInterface() {
+			return r, fmt.Errorf("action method %q has incorrect return values", action.Method)
 		}
+		r = result[0].Interface().(web.EventResponse)
+		if result[1].IsNil() {
+			return r, nil
+		}
+		err = result[1].Interface().(error)
+		return r, fmt.Errorf("failed to call action method %q: %w", action.Method, err)
+	}
+
+	switch action.Method {
+	case actionMethodReload:
+		rc, ok := v.(Identifiable)
+		if !ok {
+			return r, fmt.Errorf("compo %T does not implement Identifiable", v)
+		}
+		return OnReload(rc)
+	default:
+		return r, fmt.Errorf("action method %q not found", action.Method)
 	}
 }
 
@@ -0,0 +1,271 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+var errHookTestForbidden = errors.New("forbidden")
+
+var errHookTestBroken = errors.New("broken")
+
+type hookTestCompo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (c *hookTestCompo) CompoID() string {
+	return fmt.Sprintf("hookTestCompo:%s", c.ID)
+}
+
+func (c *hookTestCompo) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Text(c.Name)).MarshalHTML(ctx)
+}
+
+func (c *hookTestCompo) record(s string) {
+	hookTestCalls = append(hookTestCalls, s)
+}
+
+func (c *hookTestCompo) OnRestore(ctx context.Context) error {
+	c.record("OnRestore:" + c.Name)
+	return nil
+}
+
+func (c *hookTestCompo) BeforeAction(ctx context.Context, method string) error {
+	c.record("BeforeAction:" + method)
+	if c.Name == "guest" {
+		return errHookTestForbidden
+	}
+	return nil
+}
+
+func (c *hookTestCompo) AfterAction(ctx context.Context, r *web.EventResponse) {
+	c.record("AfterAction")
+	r.RunScript += ";toast('done')"
+}
+
+func (c *hookTestCompo) BeforeRender(ctx context.Context) error {
+	c.record("BeforeRender")
+	if c.Name == "broken" {
+		return errHookTestBroken
+	}
+	return nil
+}
+
+func (c *hookTestCompo) Update(ctx context.Context) (r web.EventResponse, err error) {
+	c.record("Update")
+	c.Name = "updated"
+	AppendReloadToResponse(&r, c)
+	return
+}
+
+var hookTestCalls []string
+
+func init() {
+	RegisterActionableCompoType((*hookTestCompo)(nil))
+}
+
+func TestActionHooks(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &hookTestCompo{ID: "0", Name: "page"}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	hookTestCalls = nil
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"BeforeRender"}, hookTestCalls)
+
+	post := func(name string) *httptest.ResponseRecorder {
+		req := multipartestutils.NewMultipartBuilder().
+			PageURL("/").
+			EventFunc(EventDispatchAction).
+			AddField(FieldKeyAction, PrettyJSONString(Action{
+				CompoType: "*stateful.hookTestCompo",
+				Compo:     []byte(fmt.Sprintf(`{"id":"0","name":%q}`, name)),
+				Method:    "Update",
+				Request:   []byte(`{}`),
+			})).
+			BuildEventFuncRequest()
+		w := httptest.NewRecorder()
+		pb.ServeHTTP(w, req)
+		return w
+	}
+
+	hookTestCalls = nil
+	w = post("admin")
+	assert.Equal(t, []string{"OnRestore:admin", "BeforeAction:Update", "Update", "AfterAction", "BeforeRender"}, hookTestCalls)
+	assert.Contains(t, w.Body.String(), `toast('done')`)
+	assert.Contains(t, w.Body.String(), `updated`)
+
+	hookTestCalls = nil
+	assert.PanicsWithError(t, `action method "Update" rejected: forbidden`, func() {
+		post("guest")
+	})
+	assert.Equal(t, []string{"OnRestore:guest", "BeforeAction:Update"}, hookTestCalls)
+
+	_, err := (&hookTestCompo{ID: "1", Name: "broken"}).MarshalHTML(context.Background())
+	assert.ErrorIs(t, err, errHookTestBroken)
+	assert.EqualError(t, err, "failed to render compo *stateful.hookTestCompo: broken")
+}
+
+type guardTestCompo struct {
+	ID       string `json:"id"`
+	PageSize int    `json:"page_size" query:"page_size;store:cookie"`
+}
+
+func (c *guardTestCompo) CompoID() string {
+	return fmt.Sprintf("guardTestCompo:%s", c.ID)
+}
+
+func (c *guardTestCompo) CompoVersion() string {
+	return "2"
+}
+
+func (c *guardTestCompo) CurrentVersion(ctx context.Context) (string, error) {
+	return "2", nil
+}
+
+func (c *guardTestCompo) BeforeAction(ctx context.Context, method string) error {
+	return errHookTestForbidden
+}
+
+func (c *guardTestCompo) Update(ctx context.Context) (r web.EventResponse, err error) {
+	return
+}
+
+func (c *guardTestCompo) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Text(c.ID)).MarshalHTML(ctx)
+}
+
+func init() {
+	RegisterActionableCompoType((*guardTestCompo)(nil))
+}
+
+func TestBeforeActionGuardsVersionAndStores(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = SyncQuery(&guardTestCompo{ID: "0"})
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType:     "*stateful.guardTestCompo",
+			Compo:         []byte(`{"id":"0"}`),
+			SyncQuery:     true,
+			Method:        "Update",
+			Request:       []byte(`{}`),
+			StoredQueries: map[string]string{QueryStoreCookie: "page_size=50"},
+			Version:       "1",
+		})).
+		BuildEventFuncRequest()
+	w := httptest.NewRecorder()
+	assert.PanicsWithError(t, `action method "Update" rejected: forbidden`, func() {
+		pb.ServeHTTP(w, req)
+	}, "the rejected request is not answered with the conflict")
+	assert.Empty(t, w.Result().Cookies(), "the rejected request does not store the queries")
+}
+
+type actionBaseTestRow struct {
+	ID      string `json:"id"`
+	Keyword string `json:"keyword" query:"keyword"`
+}
+
+func (c *actionBaseTestRow) CompoID() string {
+	return fmt.Sprintf("actionBaseTestRow:%s", c.ID)
+}
+
+func (c *actionBaseTestRow) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Text(c.ID)).MarshalHTML(ctx)
+}
+
+func actionBaseTestPage(n int) *web.PageBuilder {
+	return web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		var rows h.HTMLComponents
+		for i := 0; i < n; i++ {
+			rows = append(rows, &actionBaseTestRow{ID: fmt.Sprint(i)})
+		}
+		r.Body = rows
+		return
+	})
+}
+
+func TestActionableRegistersQueryTagsOncePerPage(t *testing.T) {
+	pb := actionBaseTestPage(3)
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := html.UnescapeString(w.Body.String())
+
+	// registered in the head out of the compos, so it does not depend on any of them being mounted
+	register := `(function(types) {
+	types["*stateful.actionBaseTestRow"] = types["*stateful.actionBaseTestRow"] || {`
+	assert.Equal(t, 1, strings.Count(body, register))
+	assert.Less(t, strings.Index(body, register), strings.Index(body, "</head>"))
+	assert.Equal(t, 3, strings.Count(body, `queryTags: function() { return vars.__window.__goplaid.statefulCompoTypes["*stateful.actionBaseTestRow"].queryTags(this.actionBase.query_defaults); },`))
+	assert.Equal(t, 1, strings.Count(body, `[{"name":"keyword","json_name":"keyword"`))
+	assert.Contains(t, body, `"actionBase":{"compo_type":"*stateful.actionBaseTestRow","compo_id":"actionBaseTestRow:0"}`)
+
+	// the event responses register them before their run scripts
+	pb.EventFunc("rows", func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		r.UpdatePortals = append(r.UpdatePortals,
+			&web.PortalUpdate{Name: "a", Body: &actionBaseTestRow{ID: "a"}},
+			&web.PortalUpdate{Name: "b", Body: &actionBaseTestRow{ID: "b"}},
+		)
+		r.RunScript = "vars.updated = true"
+		return
+	})
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("POST", "/?__execute_event__=rows", nil))
+	var er struct {
+		RunScript string `json:"runScript"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &er))
+	assert.True(t, strings.HasPrefix(er.RunScript, register), er.RunScript)
+	assert.Equal(t, 1, strings.Count(er.RunScript, register))
+	assert.True(t, strings.HasSuffix(er.RunScript, "; vars.updated = true"), er.RunScript)
+
+	// the compos without query tags are not registered
+	pb = web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &hookTestCompo{ID: "0"}
+		return
+	})
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body = html.UnescapeString(w.Body.String())
+	assert.NotContains(t, body, `statefulCompoTypes`)
+	assert.Contains(t, body, `queryTags: function() { return []; },`)
+}
+
+// actionBaseTestTableMaxSize is the size of the table of 200 rows before the query tags were registered by type
+const actionBaseTestTableMaxSize = 190087
+
+func BenchmarkActionableTable(b *testing.B) {
+	pb := actionBaseTestPage(200)
+	b.ReportAllocs()
+	var size int
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		size = w.Body.Len()
+	}
+	b.ReportMetric(float64(size), "html-bytes/op")
+	if size > actionBaseTestTableMaxSize {
+		b.Fatalf("the table is %d bytes, larger than %d", size, actionBaseTestTableMaxSize)
+	}
+}
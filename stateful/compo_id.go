@@ -0,0 +1,57 @@
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/theplant/osenv"
+)
+
+// DevMode makes the misuses of stateful compos panic instead of being logged, e.g. the duplicate compo ids.
+var DevMode = osenv.GetBool("STATEFUL_DEV_MODE", "Panic on the misuses of stateful compos like duplicate compo ids instead of logging them", false)
+
+// trackCompoID records the portal name of the compo rendered in the render pass of the request.
+// The portals with the same name replace each other in the browser, so the compo ids must be unique in a pass,
+// while a response may reload a compo and its ancestor which renders it again, each reload is a pass of its own.
+func trackCompoID(ctx context.Context, portalName string, c Identifiable) {
+	pass := renderPassFromContext(ctx)
+	if pass == nil {
+		return
+	}
+
+	compoType := fmt.Sprintf("%T", c)
+	pass.mu.Lock()
+	prev, duplicated := pass.compoIDs[portalName]
+	pass.compoIDs[portalName] = compoType
+	pass.mu.Unlock()
+	if !duplicated {
+		return
+	}
+
+	msg := fmt.Sprintf("stateful: compo id %q of %s is already rendered by %s in the request, derive the ids of the children with ChildCompoID", portalName, compoType, prev)
+	if DevMode {
+		panic(msg)
+	}
+	log.Println(msg)
+}
+
+// ChildCompoID derives the id of a child compo from the id of its parent,
+// so the ids are unique as long as the parts are unique among the children of the parent,
+// e.g. ChildCompoID(list, "row", row.ID) returns "TodoList:0/row:1" for the list "TodoList:0".
+func ChildCompoID(parent Identifiable, parts ...any) string {
+	return JoinCompoID(parent.CompoID(), parts...)
+}
+
+// JoinCompoID is ChildCompoID with the id of the parent, e.g. when the parent is not constructed yet.
+func JoinCompoID(parentID string, parts ...any) string {
+	if len(parts) == 0 {
+		return parentID
+	}
+	vs := make([]string, len(parts))
+	for i, part := range parts {
+		vs[i] = fmt.Sprint(part)
+	}
+	return parentID + "/" + strings.Join(vs, ":")
+}
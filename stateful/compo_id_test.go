@@ -0,0 +1,142 @@
+package stateful
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type compoIDTestItem struct {
+	ID string `json:"id"`
+}
+
+func (c *compoIDTestItem) CompoID() string {
+	return JoinCompoID("compoIDTestList:0", "item", c.ID)
+}
+
+func (c *compoIDTestItem) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Text(c.ID)).MarshalHTML(ctx)
+}
+
+func TestDuplicateCompoID(t *testing.T) {
+	newPage := func(ids ...string) *web.PageBuilder {
+		return web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+			var items h.HTMLComponents
+			for _, id := range ids {
+				items = append(items, &compoIDTestItem{ID: id})
+			}
+			r.Body = items
+			return
+		})
+	}
+
+	devMode := DevMode
+	defer func() { DevMode = devMode }()
+
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	DevMode = true
+	w := httptest.NewRecorder()
+	newPage("1", "2").ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, w.Body.String(), `portal-name='compoIDTestList:0/item:1'`)
+	assert.Contains(t, w.Body.String(), `portal-name='compoIDTestList:0/item:2'`)
+
+	assert.PanicsWithValue(t,
+		`stateful: compo id "compoIDTestList:0/item:1" of *stateful.compoIDTestItem is already rendered by *stateful.compoIDTestItem in the request, derive the ids of the children with ChildCompoID`,
+		func() {
+			newPage("1", "1").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		})
+
+	// the ids are tracked per request
+	newPage("1").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	newPage("1").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	DevMode = false
+	assert.NotPanics(t, func() {
+		newPage("1", "1").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+	assert.Contains(t, buf.String(), `stateful: compo id "compoIDTestList:0/item:1" of *stateful.compoIDTestItem is already rendered`)
+}
+
+type compoIDTestList struct {
+	ID string `json:"id"`
+}
+
+func (c *compoIDTestList) CompoID() string {
+	return "compoIDTestList:" + c.ID
+}
+
+func (c *compoIDTestList) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, &compoIDTestItem{ID: "1"}).MarshalHTML(ctx)
+}
+
+func (c *compoIDTestList) OnActionEvent(ctx context.Context, event any) (r web.EventResponse, err error) {
+	AppendReloadToResponse(&r, c)
+	return
+}
+
+func (c *compoIDTestItem) Save(ctx context.Context) (r web.EventResponse, err error) {
+	AppendReloadToResponse(&r, c)
+	return r, Emit(ctx, "saved")
+}
+
+func init() {
+	RegisterActionableCompoType((*compoIDTestList)(nil), (*compoIDTestItem)(nil))
+}
+
+func TestDuplicateCompoIDInReloads(t *testing.T) {
+	devMode := DevMode
+	defer func() { DevMode = devMode }()
+	DevMode = true
+
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &compoIDTestList{ID: "0"}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType: "*stateful.compoIDTestItem",
+			CompoID:   "compoIDTestList:0/item:1",
+			Compo:     []byte(`{"id":"1"}`),
+			Method:    "Save",
+			Request:   []byte(`{}`),
+			Ancestors: []Action{{
+				CompoType: "*stateful.compoIDTestList",
+				CompoID:   "compoIDTestList:0",
+				Compo:     []byte(`{"id":"0"}`),
+			}},
+		})).
+		BuildEventFuncRequest()
+	w := httptest.NewRecorder()
+	// the item is rendered by its reload and the reload of the list, each replaces the portals in the browser at once
+	assert.NotPanics(t, func() { pb.ServeHTTP(w, req) })
+
+	var r multipartestutils.TestEventResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+	require.Len(t, r.UpdatePortals, 2)
+	assert.Equal(t, "compoIDTestList:0/item:1", r.UpdatePortals[0].Name)
+	assert.Equal(t, "compoIDTestList:0", r.UpdatePortals[1].Name)
+	assert.Contains(t, r.UpdatePortals[1].Body, `portal-name='compoIDTestList:0/item:1'`)
+}
+
+func TestChildCompoID(t *testing.T) {
+	parent := &compoIDTestItem{ID: "1"}
+	assert.Equal(t, "compoIDTestList:0/item:1/row:2:name", ChildCompoID(parent, "row", 2, "name"))
+	assert.Equal(t, "compoIDTestList:0/item:1", ChildCompoID(parent))
+	assert.Equal(t, "a/b", JoinCompoID("a", "b"))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -17,13 +18,19 @@ var ErrInjectorNotFound = errors.New("injector not found")
 type InjectorName string
 
 type DependencyCenter struct {
-	mu        sync.RWMutex
-	injectors map[string]*inject.Injector
+	mu              sync.RWMutex
+	injectors       map[string]*inject.Injector
+	parents         map[string]string
+	scopedProviders map[string][]any
+	providers       map[string][]*providerRecord
 }
 
 func NewDependencyCenter() *DependencyCenter {
 	return &DependencyCenter{
-		injectors: map[string]*inject.Injector{},
+		injectors:       map[string]*inject.Injector{},
+		parents:         map[string]string{},
+		scopedProviders: map[string][]any{},
+		providers:       map[string][]*providerRecord{},
 	}
 }
 
@@ -68,12 +75,23 @@ func (dc *DependencyCenter) RegisterInjector(name string, opts ...RegisterInject
 	}
 
 	inj := inject.New()
-	inj.Provide(func() InjectorName { return InjectorName(name) })
-	inj.Provide(func() *DependencyCenter { return dc })
+	builtins := []any{
+		func() InjectorName { return InjectorName(name) },
+		func() *DependencyCenter { return dc },
+	}
+	inj.Provide(builtins...)
 	if parentInjector != nil {
 		inj.SetParent(parentInjector)
 	}
 	dc.injectors[name] = inj
+	dc.parents[name] = parent
+	dc.providers[name] = append(dc.providers[name], &providerRecord{
+		outs:    []reflect.Type{reflect.TypeOf(inj)},
+		builtin: true,
+	})
+	for _, f := range builtins {
+		dc.providers[name] = append(dc.providers[name], newProviderRecord(f, false, true))
+	}
 }
 
 func (dc *DependencyCenter) Injector(name string) (*inject.Injector, error) {
@@ -91,7 +109,13 @@ func (dc *DependencyCenter) Provide(name string, fs ...any) error {
 	if err != nil {
 		return err
 	}
-	return inj.Provide(fs...)
+	for _, f := range fs {
+		if err := inj.Provide(f); err != nil {
+			return err
+		}
+		dc.recordProvider(name, f, false)
+	}
+	return nil
 }
 
 func (dc *DependencyCenter) MustProvide(name string, fs ...any) {
@@ -106,11 +130,20 @@ func (dc *DependencyCenter) Inject(injectorName string, c h.HTMLComponent) (h.HT
 	if err != nil {
 		return nil, err
 	}
-	if err := inj.Apply(Unwrap(c)); err != nil {
-		return nil, err
+	// scoped dependencies can only be resolved when rendering with the request context
+	scoped := dc.hasScopedProviders(injectorName)
+	if !scoped {
+		if err := inj.Apply(Unwrap(c)); err != nil {
+			return nil, err
+		}
 	}
 	return h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
 		ctx = withInjectorName(ctx, injectorName)
+		if scoped {
+			if err := dc.Apply(ctx, c); err != nil {
+				return nil, err
+			}
+		}
 		return c.MarshalHTML(ctx)
 	}), nil
 }
@@ -128,7 +161,7 @@ func (dc *DependencyCenter) Apply(ctx context.Context, target h.HTMLComponent) e
 	if name == "" {
 		return nil
 	}
-	inj, err := dc.Injector(name)
+	inj, err := dc.scopedInjector(ctx, name)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,307 @@
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/qor5/web/v3"
+	h "github.com/theplant/htmlgo"
+)
+
+type providerRecord struct {
+	outs    []reflect.Type
+	ins     []reflect.Type
+	scoped  bool
+	builtin bool
+}
+
+func newProviderRecord(f any, scoped bool, builtin bool) *providerRecord {
+	rt := reflect.TypeOf(f)
+	rec := &providerRecord{
+		outs:    providerOutTypes(f),
+		scoped:  scoped,
+		builtin: builtin,
+	}
+	for i := 0; i < rt.NumIn(); i++ {
+		rec.ins = append(rec.ins, rt.In(i))
+	}
+	return rec
+}
+
+func (dc *DependencyCenter) recordProvider(name string, f any, scoped bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.providers[name] = append(dc.providers[name], newProviderRecord(f, scoped, false))
+}
+
+// types which are always available in a request scope, see scopedInjector
+var scopedBuiltinTypes = []reflect.Type{
+	reflect.TypeOf((*context.Context)(nil)).Elem(),
+	reflect.TypeOf((*RequestScope)(nil)),
+	reflect.TypeOf((*web.EventContext)(nil)),
+	reflect.TypeOf((*http.Request)(nil)),
+}
+
+type ProviderInfo struct {
+	Types   []string `json:"types"`
+	Deps    []string `json:"deps,omitempty"`
+	Scoped  bool     `json:"scoped,omitempty"`
+	Builtin bool     `json:"builtin,omitempty"`
+}
+
+type InjectorInfo struct {
+	Name      string         `json:"name"`
+	Parent    string         `json:"parent,omitempty"`
+	Providers []ProviderInfo `json:"providers"`
+}
+
+type DependencyIssueKind string
+
+const (
+	// DependencyIssueUnsatisfiable means a provider depends on a type that no injector in the chain provides.
+	DependencyIssueUnsatisfiable DependencyIssueKind = "unsatisfiable"
+	// DependencyIssueShadowed means a type provided by an ancestor is provided again by a descendant.
+	DependencyIssueShadowed DependencyIssueKind = "shadowed"
+)
+
+type DependencyIssue struct {
+	Kind     DependencyIssueKind `json:"kind"`
+	Injector string              `json:"injector"`
+	Type     string              `json:"type"`
+	Message  string              `json:"message"`
+}
+
+// DependencyEdge is the dependency of a provided type on another type and the injector which provides it.
+type DependencyEdge struct {
+	FromInjector string `json:"fromInjector"`
+	FromType     string `json:"fromType"`
+	ToInjector   string `json:"toInjector,omitempty"` // empty if unsatisfiable
+	ToType       string `json:"toType"`
+}
+
+type DependencyGraph struct {
+	Injectors []InjectorInfo    `json:"injectors"`
+	Edges     []DependencyEdge  `json:"edges,omitempty"`
+	Issues    []DependencyIssue `json:"issues,omitempty"`
+}
+
+// lookupLocked finds the nearest injector in the chain of name which provides rt.
+// Scoped lookups also see the scoped providers and builtins of the chain.
+func (dc *DependencyCenter) lookupLocked(name string, rt reflect.Type, scoped bool) (string, *providerRecord) {
+	if scoped {
+		for _, bt := range scopedBuiltinTypes {
+			if bt == rt {
+				return name, &providerRecord{outs: []reflect.Type{rt}, scoped: true, builtin: true}
+			}
+		}
+		for cur := name; cur != ""; cur = dc.parents[cur] {
+			if rec := findProviderRecord(dc.providers[cur], rt, true); rec != nil {
+				return cur, rec
+			}
+		}
+	}
+	for cur := name; cur != ""; cur = dc.parents[cur] {
+		if rec := findProviderRecord(dc.providers[cur], rt, false); rec != nil {
+			return cur, rec
+		}
+	}
+	return "", nil
+}
+
+func findProviderRecord(recs []*providerRecord, rt reflect.Type, scoped bool) *providerRecord {
+	for _, rec := range recs {
+		if rec.scoped != scoped {
+			continue
+		}
+		for _, out := range rec.outs {
+			if out == rt {
+				return rec
+			}
+		}
+	}
+	return nil
+}
+
+func typeStrings(rts []reflect.Type) []string {
+	vs := make([]string, 0, len(rts))
+	for _, rt := range rts {
+		vs = append(vs, rt.String())
+	}
+	return vs
+}
+
+// Graph returns the injectors, their providers and the issues found in the DependencyCenter.
+func (dc *DependencyCenter) Graph() *DependencyGraph {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	names := make([]string, 0, len(dc.injectors))
+	for name := range dc.injectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	g := &DependencyGraph{}
+	for _, name := range names {
+		info := InjectorInfo{
+			Name:   name,
+			Parent: dc.parents[name],
+		}
+		for _, rec := range dc.providers[name] {
+			info.Providers = append(info.Providers, ProviderInfo{
+				Types:   typeStrings(rec.outs),
+				Deps:    typeStrings(rec.ins),
+				Scoped:  rec.scoped,
+				Builtin: rec.builtin,
+			})
+			if rec.builtin {
+				continue
+			}
+
+			for _, in := range rec.ins {
+				provider, _ := dc.lookupLocked(name, in, rec.scoped)
+				for _, out := range rec.outs {
+					g.Edges = append(g.Edges, DependencyEdge{
+						FromInjector: name,
+						FromType:     out.String(),
+						ToInjector:   provider,
+						ToType:       in.String(),
+					})
+				}
+				if provider == "" {
+					g.Issues = append(g.Issues, DependencyIssue{
+						Kind:     DependencyIssueUnsatisfiable,
+						Injector: name,
+						Type:     in.String(),
+						Message: fmt.Sprintf("provider of %s in injector %q depends on %s which is not provided",
+							strings.Join(typeStrings(rec.outs), ", "), name, in),
+					})
+				}
+			}
+
+			for _, out := range rec.outs {
+				var shadowed string
+				if rec.scoped {
+					// scoped providers also shadow the long-lived ones of the same chain
+					if findProviderRecord(dc.providers[name], out, false) != nil {
+						shadowed = name
+					}
+				}
+				if shadowed == "" && dc.parents[name] != "" {
+					shadowed, _ = dc.lookupLocked(dc.parents[name], out, rec.scoped)
+				}
+				if shadowed == "" {
+					continue
+				}
+				g.Issues = append(g.Issues, DependencyIssue{
+					Kind:     DependencyIssueShadowed,
+					Injector: name,
+					Type:     out.String(),
+					Message:  fmt.Sprintf("%s provided in injector %q shadows the one provided in injector %q", out, name, shadowed),
+				})
+			}
+		}
+		g.Injectors = append(g.Injectors, info)
+	}
+	return g
+}
+
+// Check returns the unsatisfiable and shadowed providers.
+// Shadowing is often intended, so it is up to the caller to decide which issues are fatal.
+func (dc *DependencyCenter) Check() []DependencyIssue {
+	return dc.Graph().Issues
+}
+
+func dotID(vs ...string) string {
+	return fmt.Sprintf("%q", strings.Join(vs, "|"))
+}
+
+// DOT renders the graph in Graphviz DOT format.
+func (g *DependencyGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph DependencyCenter {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+	for i, info := range g.Injectors {
+		fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "\t\tlabel=%q;\n", info.Name)
+		fmt.Fprintf(&b, "\t\t%s [label=%q, shape=folder];\n", dotID("injector", info.Name), info.Name)
+		for _, p := range info.Providers {
+			if p.Builtin {
+				continue
+			}
+			style := ""
+			if p.Scoped {
+				style = ", style=dashed"
+			}
+			for _, typ := range p.Types {
+				fmt.Fprintf(&b, "\t\t%s [label=%q%s];\n", dotID(info.Name, typ), typ, style)
+			}
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, info := range g.Injectors {
+		if info.Parent != "" {
+			fmt.Fprintf(&b, "\t%s -> %s [label=\"parent\", style=dashed];\n", dotID("injector", info.Name), dotID("injector", info.Parent))
+		}
+	}
+	for _, e := range g.Edges {
+		to := dotID(e.ToInjector, e.ToType)
+		if e.ToInjector == "" {
+			to = dotID("missing", e.ToType)
+			fmt.Fprintf(&b, "\t%s [label=%q, color=red];\n", to, e.ToType)
+		}
+		fmt.Fprintf(&b, "\t%s -> %s;\n", dotID(e.FromInjector, e.FromType), to)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+type FieldDependency struct {
+	Field    string `json:"field"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+	Scoped   bool   `json:"scoped,omitempty"`
+	Injector string `json:"injector,omitempty"` // empty if not provided
+}
+
+// Explain reports which injector provides each `inject` field of the compo when applied with the named injector.
+func (dc *DependencyCenter) Explain(injectorName string, c h.HTMLComponent) ([]FieldDependency, error) {
+	if _, err := dc.Injector(injectorName); err != nil {
+		return nil, err
+	}
+	rt := unwrapPtrType(reflect.TypeOf(Unwrap(c)))
+	if rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%q expected struct, got %v", rt.String(), rt.Kind())
+	}
+
+	scoped := dc.hasScopedProviders(injectorName)
+
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	var deps []FieldDependency
+	for i := 0; i < rt.NumField(); i++ {
+		structField := rt.Field(i)
+		tag, ok := structField.Tag.Lookup("inject")
+		if !ok {
+			continue
+		}
+		injector, rec := dc.lookupLocked(injectorName, structField.Type, scoped)
+		dep := FieldDependency{
+			Field:    structField.Name,
+			Type:     structField.Type.String(),
+			Optional: strings.TrimSpace(tag) == "optional",
+			Injector: injector,
+		}
+		if rec != nil {
+			dep.Scoped = rec.scoped
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
@@ -0,0 +1,85 @@
+package stateful
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type graphTestStorage struct{}
+
+type graphTestDep struct{}
+
+type graphTestCache struct{}
+
+func TestDependencyGraph(t *testing.T) {
+	dc := NewDependencyCenter()
+	dc.RegisterInjector("top")
+	dc.RegisterInjector("top/sub", WithParent("top"))
+	dc.MustProvide("top",
+		func() *graphTestStorage { return &graphTestStorage{} },
+		func(s *graphTestStorage) *graphTestDep { return &graphTestDep{} },
+	)
+	dc.MustProvide("top/sub", func(s *graphTestStorage, c *graphTestCache) *graphTestDep { return &graphTestDep{} })
+	dc.MustProvideScoped("top", func(s *graphTestStorage) *scopeTestUser { return &scopeTestUser{} })
+
+	g := dc.Graph()
+	require.Len(t, g.Injectors, 2)
+	assert.Equal(t, "top", g.Injectors[0].Name)
+	assert.Equal(t, "top/sub", g.Injectors[1].Name)
+	assert.Equal(t, "top", g.Injectors[1].Parent)
+
+	var userProvider *ProviderInfo
+	for i, p := range g.Injectors[0].Providers {
+		if p.Types[0] == "*stateful.scopeTestUser" {
+			userProvider = &g.Injectors[0].Providers[i]
+		}
+	}
+	require.NotNil(t, userProvider)
+	assert.True(t, userProvider.Scoped)
+	assert.Equal(t, []string{"*stateful.graphTestStorage"}, userProvider.Deps)
+
+	assert.Equal(t, []DependencyIssue{
+		{
+			Kind:     DependencyIssueUnsatisfiable,
+			Injector: "top/sub",
+			Type:     "*stateful.graphTestCache",
+			Message:  `provider of *stateful.graphTestDep in injector "top/sub" depends on *stateful.graphTestCache which is not provided`,
+		},
+		{
+			Kind:     DependencyIssueShadowed,
+			Injector: "top/sub",
+			Type:     "*stateful.graphTestDep",
+			Message:  `*stateful.graphTestDep provided in injector "top/sub" shadows the one provided in injector "top"`,
+		},
+	}, dc.Check())
+
+	dot := g.DOT()
+	assert.Contains(t, dot, `"injector|top/sub" -> "injector|top" [label="parent", style=dashed];`)
+	assert.Contains(t, dot, `"top/sub|*stateful.graphTestDep" -> "top|*stateful.graphTestStorage";`)
+	assert.Contains(t, dot, `"top/sub|*stateful.graphTestDep" -> "missing|*stateful.graphTestCache";`)
+	assert.Contains(t, dot, `"top|*stateful.scopeTestUser" [label="*stateful.scopeTestUser", style=dashed];`)
+
+	b, err := json.Marshal(g)
+	require.NoError(t, err)
+	var dumped DependencyGraph
+	require.NoError(t, json.Unmarshal(b, &dumped))
+	assert.Equal(t, g.Edges, dumped.Edges)
+	assert.Contains(t, dumped.Edges, DependencyEdge{
+		FromInjector: "top/sub",
+		FromType:     "*stateful.graphTestDep",
+		ToType:       "*stateful.graphTestCache",
+	})
+
+	deps, err := dc.Explain("top/sub", &scopeTestCompo{})
+	require.NoError(t, err)
+	assert.Equal(t, []FieldDependency{
+		{Field: "db", Type: "*stateful.scopeTestDB"},
+		{Field: "user", Type: "*stateful.scopeTestUser", Scoped: true, Injector: "top"},
+	}, deps)
+
+	_, err = dc.Explain("unknown", &scopeTestCompo{})
+	assert.ErrorIs(t, err, ErrInjectorNotFound)
+}
@@ -0,0 +1,190 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/qor5/web/v3"
+	h "github.com/theplant/htmlgo"
+)
+
+// ActionEventHandler handles the events emitted by the actions of its descendant compos in the same request,
+// e.g. a list reloads itself when one of its row editors saved a row.
+// The handler must be Identifiable, the descendants post its id and the browser adds its live state to the action,
+// so it is restored with the changes made after it was rendered and the descendant does not need to know about it.
+type ActionEventHandler interface {
+	OnActionEvent(ctx context.Context, event any) (web.EventResponse, error)
+}
+
+var ErrNotInAction = errors.New("not in an action")
+
+type actionEventsCtxKey struct{}
+
+type actionEvents struct {
+	mu     sync.Mutex
+	events []any
+}
+
+func withActionEvents(ctx context.Context) (context.Context, *actionEvents) {
+	events := &actionEvents{}
+	return context.WithValue(ctx, actionEventsCtxKey{}, events), events
+}
+
+// Emit emits the event from an action method, it bubbles to the ancestor compos which implement ActionEventHandler,
+// the nearest first, after the action method returns.
+func Emit(ctx context.Context, event any) error {
+	events, ok := ctx.Value(actionEventsCtxKey{}).(*actionEvents)
+	if !ok {
+		return ErrNotInAction
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.events = append(events.events, event)
+	return nil
+}
+
+type actionAncestorsCtxKey struct{}
+
+// withActionAncestors sets the ancestors which handle events, the nearest first
+func withActionAncestors(ctx context.Context, ancestors []Action) context.Context {
+	return context.WithValue(ctx, actionAncestorsCtxKey{}, ancestors)
+}
+
+func actionAncestorsFromContext(ctx context.Context) []Action {
+	ancestors, _ := ctx.Value(actionAncestorsCtxKey{}).([]Action)
+	return ancestors
+}
+
+// actionAncestor returns the ancestor rendered into the actions of the descendants, it has no state
+func actionAncestor(action Action) Action {
+	return Action{
+		CompoType:     action.CompoType,
+		CompoID:       action.CompoID,
+		Injector:      action.Injector,
+		SyncQuery:     action.SyncQuery,
+		QueryDefaults: action.QueryDefaults,
+	}
+}
+
+// withActionContext sets the context in which the compo of the action was rendered
+func withActionContext(ctx context.Context, action *Action) context.Context {
+	ctx = withInjectorName(ctx, action.Injector)
+	if action.SyncQuery {
+		ctx = withSyncQuery(ctx, action.CompoType, action.QueryDefaults)
+	} else {
+		ctx = context.WithValue(ctx, syncQueryCtxKey{}, nil)
+	}
+	return withActionAncestors(ctx, action.Ancestors)
+}
+
+// restoreActionCompo unmarshals and injects the compo of the action, the returned context is the one it was rendered with
+func restoreActionCompo(ctx context.Context, dc *DependencyCenter, action *Action) (h.HTMLComponent, context.Context, error) {
+	v, err := newActionableCompo(action.CompoType)
+	if err != nil {
+		return nil, ctx, err
+	}
+	if err := json.Unmarshal(action.Compo, v); err != nil {
+		return nil, ctx, err
+	}
+
+	ctx = withActionContext(ctx, action)
+	if action.Injector != "" {
+		if err := dc.Apply(ctx, v); err != nil {
+			return nil, ctx, err
+		}
+	}
+	if hook, ok := v.(ActionRestorer); ok {
+		if err := hook.OnRestore(ctx); err != nil {
+			return nil, ctx, fmt.Errorf("failed to restore compo %T: %w", v, err)
+		}
+	}
+	return v, ctx, nil
+}
+
+// bubbleActionEvents delivers the events to the ancestors, the events emitted by an ancestor bubble to the farther ones
+func bubbleActionEvents(ctx context.Context, dc *DependencyCenter, ancestors []Action, events []any) (r web.EventResponse, err error) {
+	for i := 0; i < len(ancestors) && len(events) > 0; i++ {
+		ancestor := ancestors[i]
+		if len(ancestor.Compo) == 0 || string(ancestor.Compo) == "null" {
+			// not mounted in the page anymore, the events bubble to the farther ones
+			continue
+		}
+		ancestor.Ancestors = ancestors[i+1:]
+		v, actx, err := restoreActionCompo(ctx, dc, &ancestor)
+		if err != nil {
+			return r, err
+		}
+		handler, ok := v.(ActionEventHandler)
+		if !ok {
+			return r, fmt.Errorf("compo %T does not implement ActionEventHandler", v)
+		}
+
+		actx, emitted := withActionEvents(actx)
+		for _, event := range events {
+			ar, err := handler.OnActionEvent(actx, event)
+			if err != nil {
+				return r, fmt.Errorf("compo %T failed to handle event %T: %w", v, event, err)
+			}
+			renderInActionContext(&ar, &ancestor)
+			mergeEventResponse(&r, ar)
+		}
+		events = append(events, emitted.events...)
+	}
+	return r, nil
+}
+
+// renderInActionContext makes the bodies rendered with the context of the action compo instead of the dispatched one
+func renderInActionContext(r *web.EventResponse, action *Action) {
+	wrap := func(c h.HTMLComponent) h.HTMLComponent {
+		if c == nil {
+			return nil
+		}
+		return h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+			return c.MarshalHTML(withActionContext(ctx, action))
+		})
+	}
+	r.Body = wrap(r.Body)
+	for _, up := range r.UpdatePortals {
+		up.Body = wrap(up.Body)
+	}
+}
+
+// mergeEventResponse merges src into dst, the fields already set in dst take precedence
+func mergeEventResponse(dst *web.EventResponse, src web.EventResponse) {
+	if dst.PageTitle == "" {
+		dst.PageTitle = src.PageTitle
+	}
+	if dst.Body == nil {
+		dst.Body = src.Body
+	}
+	dst.Reload = dst.Reload || src.Reload
+	if dst.PushState == nil {
+		dst.PushState = src.PushState
+	}
+	if dst.RedirectURL == "" {
+		dst.RedirectURL = src.RedirectURL
+	}
+	dst.ReloadPortals = append(dst.ReloadPortals, src.ReloadPortals...)
+	dst.UpdatePortals = append(dst.UpdatePortals, src.UpdatePortals...)
+	if dst.Data == nil {
+		dst.Data = src.Data
+	}
+	if src.RunScript != "" {
+		if dst.RunScript != "" {
+			dst.RunScript += ";\n"
+		}
+		dst.RunScript += src.RunScript
+	}
+	dst.NotModified = dst.NotModified && src.NotModified
+	if dst.AutoReload == nil {
+		dst.AutoReload = src.AutoReload
+	}
+	if dst.Cache == nil {
+		dst.Cache = src.Cache
+	}
+	dst.InvalidateCaches = append(dst.InvalidateCaches, src.InvalidateCaches...)
+	dst.States = append(dst.States, src.States...)
+}
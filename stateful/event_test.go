@@ -0,0 +1,135 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type eventTestRowSaved struct {
+	ID string
+}
+
+type eventTestList struct {
+	ID    string   `json:"id"`
+	Saved []string `json:"saved"`
+}
+
+func (c *eventTestList) CompoID() string {
+	return fmt.Sprintf("eventTestList:%s", c.ID)
+}
+
+func (c *eventTestList) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c,
+		h.Text(fmt.Sprintf("saved:%v", c.Saved)),
+		&eventTestRow{ID: "a"},
+	).MarshalHTML(ctx)
+}
+
+func (c *eventTestList) OnActionEvent(ctx context.Context, event any) (r web.EventResponse, err error) {
+	switch ev := event.(type) {
+	case eventTestRowSaved:
+		c.Saved = append(c.Saved, ev.ID)
+		AppendReloadToResponse(&r, c)
+		r.SetState(web.StateVars, "savedCount", len(c.Saved))
+		web.InvalidateCaches(&r, "eventTestList")
+	}
+	return
+}
+
+type eventTestRow struct {
+	ID string `json:"id"`
+}
+
+func (c *eventTestRow) CompoID() string {
+	return fmt.Sprintf("eventTestRow:%s", c.ID)
+}
+
+func (c *eventTestRow) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Text("row:"+c.ID)).MarshalHTML(ctx)
+}
+
+func (c *eventTestRow) Save(ctx context.Context) (r web.EventResponse, err error) {
+	r.RunScript = "alert('saved')"
+	return r, Emit(ctx, eventTestRowSaved{ID: c.ID})
+}
+
+func init() {
+	RegisterActionableCompoType((*eventTestList)(nil), (*eventTestRow)(nil))
+}
+
+func TestActionEventBubbling(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &eventTestList{ID: "0", Saved: []string{"x"}}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	assert.Equal(t, 1, strings.Count(body, `"ancestors"`), "only the row carries the list")
+	assert.Equal(t, 2, strings.Count(body, `"compo_type":"*stateful.eventTestList"`))
+	assert.Equal(t, 1, strings.Count(body, `"saved":["x"]`), "the row carries the id of the list instead of its state")
+	assert.Contains(t, body, `:expose-as='["__statefulCompos","eventTestList:0"]'`)
+	assert.Contains(t, body, `ancestor.compo = locals ? JSON.parse(JSON.stringify(locals.compo)) : null;`)
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType: "*stateful.eventTestRow",
+			Compo:     []byte(`{"id":"a"}`),
+			Method:    "Save",
+			Request:   []byte(`{}`),
+			Ancestors: []Action{
+				{
+					// unmounted, so the browser posts no state
+					CompoType: "*stateful.eventTestList",
+					CompoID:   "eventTestList:1",
+					Compo:     []byte(`null`),
+				},
+				{
+					// the live state changed after the page was rendered
+					CompoType: "*stateful.eventTestList",
+					CompoID:   "eventTestList:0",
+					Compo:     []byte(`{"id":"0","saved":["x","y"]}`),
+					SyncQuery: true,
+				},
+			},
+		})).
+		BuildEventFuncRequest()
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+
+	var r struct {
+		RunScript        string             `json:"runScript"`
+		States           []*web.StateUpdate `json:"states"`
+		InvalidateCaches []string           `json:"invalidateCaches"`
+		UpdatePortals    []struct {
+			Name string `json:"name"`
+			Body string `json:"body"`
+		} `json:"updatePortals"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+	assert.Equal(t, "alert('saved')", r.RunScript)
+	require.Len(t, r.UpdatePortals, 1)
+	assert.Equal(t, "eventTestList:0", r.UpdatePortals[0].Name)
+	assert.Contains(t, r.UpdatePortals[0].Body, "saved:[x y a]")
+	// rendered in the context of the list instead of the row
+	assert.Contains(t, r.UpdatePortals[0].Body, `"sync_query":true`)
+	// the other fields of the response of the ancestor are kept too
+	assert.Equal(t, []*web.StateUpdate{{Target: web.StateVars, Path: "savedCount", Value: float64(3)}}, r.States)
+	assert.Equal(t, []string{"eventTestList"}, r.InvalidateCaches)
+
+	assert.ErrorIs(t, Emit(context.Background(), eventTestRowSaved{}), ErrNotInAction)
+}
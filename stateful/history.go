@@ -0,0 +1,243 @@
+package stateful
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/qor5/web/v3"
+	h "github.com/theplant/htmlgo"
+)
+
+// Historical compos record their state before and after each action which changed it,
+// so that the built-in actions ActionMethodUndo and ActionMethodRedo restore it and reload the compo, e.g.
+//
+//	h.Button("Undo").Attr("@click", stateful.PostAction(ctx, c, stateful.ActionMethodUndo, nil).Go())
+type Historical interface {
+	Identifiable
+	HistoryOptions() HistoryOptions
+}
+
+const (
+	ActionMethodUndo = "Undo"
+	ActionMethodRedo = "Redo"
+)
+
+const DefaultHistoryLimit = 50
+
+type HistoryOptions struct {
+	Store HistoryStore
+	Limit int // defaults to DefaultHistoryLimit, the oldest entries are dropped beyond it
+}
+
+// HistoryEntry is the state snapshots around an action
+type HistoryEntry struct {
+	Method string          `json:"method"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// History is the recorded entries of a compo, the entries before Cursor are undoable and the others are redoable.
+type History struct {
+	Entries []HistoryEntry `json:"entries"`
+	Cursor  int            `json:"cursor"`
+}
+
+func (hist *History) CanUndo() bool {
+	return hist.Cursor > 0
+}
+
+func (hist *History) CanRedo() bool {
+	return hist.Cursor < len(hist.Entries)
+}
+
+// record drops the redoable entries and appends the entry, keeping at most limit entries
+func (hist *History) record(entry HistoryEntry, limit int) {
+	hist.Entries = append(hist.Entries[:hist.Cursor], entry)
+	if len(hist.Entries) > limit {
+		hist.Entries = hist.Entries[len(hist.Entries)-limit:]
+	}
+	hist.Cursor = len(hist.Entries)
+}
+
+// HistoryStore keeps the histories of the compos, the key is unique per compo.
+type HistoryStore interface {
+	// LoadHistory returns nil if nothing is saved.
+	LoadHistory(evCtx *web.EventContext, key string) (*History, error)
+	SaveHistory(evCtx *web.EventContext, key string, hist *History) error
+}
+
+// StorageHistoryStore saves the histories as json to the storage per user, e.g. MemoryQueryStorage or one backed by redis.
+type StorageHistoryStore struct {
+	Storage QueryStorage
+	// UserKey returns the key of the user of the request, nothing is loaded or saved if it is "".
+	UserKey func(r *http.Request) (string, error)
+}
+
+func (s *StorageHistoryStore) storageKey(evCtx *web.EventContext, key string) (string, error) {
+	userKey, err := s.UserKey(evCtx.R)
+	if err != nil || userKey == "" {
+		return "", err
+	}
+	return userKey + ":" + key, nil
+}
+
+func (s *StorageHistoryStore) LoadHistory(evCtx *web.EventContext, key string) (*History, error) {
+	storageKey, err := s.storageKey(evCtx, key)
+	if err != nil || storageKey == "" {
+		return nil, err
+	}
+	v, err := s.Storage.Get(evCtx.R.Context(), storageKey)
+	if err != nil || v == "" {
+		return nil, err
+	}
+	hist := &History{}
+	if err := json.Unmarshal([]byte(v), hist); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal history: %w", err)
+	}
+	return hist, nil
+}
+
+func (s *StorageHistoryStore) SaveHistory(evCtx *web.EventContext, key string, hist *History) error {
+	storageKey, err := s.storageKey(evCtx, key)
+	if err != nil || storageKey == "" {
+		return err
+	}
+	b, err := json.Marshal(hist)
+	if err != nil {
+		return err
+	}
+	return s.Storage.Set(evCtx.R.Context(), storageKey, string(b))
+}
+
+// historyKey returns the key of the history of the compo in the page,
+// the compos with the same id in different pages or injectors have their own histories.
+func historyKey(ctx context.Context, c Identifiable) string {
+	evCtx := web.MustGetEventContext(ctx)
+	hash := MurmurHash3(fmt.Sprintf("%s:%s:%T:%s", evCtx.R.URL.Path, injectorNameFromContext(ctx), c, c.CompoID()))
+	return fmt.Sprintf("__history_%s__", hash)
+}
+
+func historyOptions(c Historical) (HistoryOptions, error) {
+	o := c.HistoryOptions()
+	if o.Store == nil {
+		return o, fmt.Errorf("compo %T has no history store", c)
+	}
+	if o.Limit <= 0 {
+		o.Limit = DefaultHistoryLimit
+	}
+	return o, nil
+}
+
+// LoadHistory returns the history of the compo, e.g. to disable the undo button if !CanUndo().
+func LoadHistory(ctx context.Context, c Historical) (*History, error) {
+	o, err := historyOptions(c)
+	if err != nil {
+		return nil, err
+	}
+	hist, err := o.Store.LoadHistory(web.MustGetEventContext(ctx), historyKey(ctx, c))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history of compo %T: %w", c, err)
+	}
+	if hist == nil {
+		hist = &History{}
+	}
+	return hist, nil
+}
+
+// isHistoryMethod reports whether the method is the built-in undo or redo of the compo
+func isHistoryMethod(c h.HTMLComponent, method string) bool {
+	if _, ok := c.(Historical); !ok {
+		return false
+	}
+	if method != ActionMethodUndo && method != ActionMethodRedo {
+		return false
+	}
+	// the methods of the compo take precedence
+	return !reflect.ValueOf(c).MethodByName(method).IsValid()
+}
+
+// recordHistory records the state change made by the action
+func recordHistory(ctx context.Context, c h.HTMLComponent, action *Action) error {
+	hc, ok := c.(Historical)
+	if !ok || action.Method == actionMethodReload {
+		return nil
+	}
+	var before, after bytes.Buffer
+	if err := json.Compact(&before, action.Compo); err != nil {
+		return err
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := json.Compact(&after, b); err != nil {
+		return err
+	}
+	if bytes.Equal(before.Bytes(), after.Bytes()) {
+		return nil
+	}
+
+	o, err := historyOptions(hc)
+	if err != nil {
+		return err
+	}
+	hist, err := LoadHistory(ctx, hc)
+	if err != nil {
+		return err
+	}
+	hist.record(HistoryEntry{
+		Method: action.Method,
+		Before: before.Bytes(),
+		After:  after.Bytes(),
+	}, o.Limit)
+	return o.Store.SaveHistory(web.MustGetEventContext(ctx), historyKey(ctx, hc), hist)
+}
+
+// travelHistory restores the state of the previous entry for undo or the next one for redo, and reloads the compo
+func travelHistory(ctx context.Context, dc *DependencyCenter, c h.HTMLComponent, action *Action) (r web.EventResponse, err error) {
+	hc := c.(Historical)
+	o, err := historyOptions(hc)
+	if err != nil {
+		return r, err
+	}
+	hist, err := LoadHistory(ctx, hc)
+	if err != nil {
+		return r, err
+	}
+
+	var state json.RawMessage
+	switch action.Method {
+	case ActionMethodUndo:
+		if !hist.CanUndo() {
+			return r, nil
+		}
+		hist.Cursor--
+		state = hist.Entries[hist.Cursor].Before
+	default:
+		if !hist.CanRedo() {
+			return r, nil
+		}
+		state = hist.Entries[hist.Cursor].After
+		hist.Cursor++
+	}
+
+	restoring := *action
+	restoring.Compo = state
+	v, _, err := restoreActionCompo(ctx, dc, &restoring)
+	if err != nil {
+		return r, err
+	}
+	ident, ok := v.(Identifiable)
+	if !ok || ident.CompoID() != hc.CompoID() {
+		return r, fmt.Errorf("the history of compo %q has the state of another compo", hc.CompoID())
+	}
+	if err := o.Store.SaveHistory(web.MustGetEventContext(ctx), historyKey(ctx, hc), hist); err != nil {
+		return r, err
+	}
+	AppendReloadToResponse(&r, ident)
+	return r, nil
+}
@@ -0,0 +1,135 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+var historyTestStore = &StorageHistoryStore{
+	Storage: &MemoryQueryStorage{},
+	UserKey: func(r *http.Request) (string, error) {
+		return r.Header.Get("X-User"), nil
+	},
+}
+
+type historyTestEditor struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+func (c *historyTestEditor) CompoID() string {
+	return fmt.Sprintf("historyTestEditor:%s", c.ID)
+}
+
+func (c *historyTestEditor) HistoryOptions() HistoryOptions {
+	return HistoryOptions{Store: historyTestStore, Limit: 3}
+}
+
+func (c *historyTestEditor) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Span(c.Text).Class("text")).MarshalHTML(ctx)
+}
+
+type historyTestSetTextRequest struct {
+	Text string `json:"text"`
+}
+
+func (c *historyTestEditor) SetText(ctx context.Context, req historyTestSetTextRequest) (r web.EventResponse, err error) {
+	c.Text = req.Text
+	AppendReloadToResponse(&r, c)
+	return
+}
+
+func init() {
+	RegisterActionableCompoType((*historyTestEditor)(nil))
+}
+
+func TestHistory(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &historyTestEditor{ID: "0"}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	text, page := "a", "/"
+	post := func(user string, method string, request any) string {
+		req := multipartestutils.NewMultipartBuilder().
+			PageURL(page).
+			EventFunc(EventDispatchAction).
+			AddField(FieldKeyAction, PrettyJSONString(Action{
+				CompoType: "*stateful.historyTestEditor",
+				Compo:     []byte(PrettyJSONString(&historyTestEditor{ID: "0", Text: text})),
+				Method:    method,
+				Request:   []byte(PrettyJSONString(request)),
+			})).
+			BuildEventFuncRequest()
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		pb.ServeHTTP(w, req)
+		var r multipartestutils.TestEventResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+		if len(r.UpdatePortals) == 0 {
+			return ""
+		}
+		m := regexp.MustCompile(`<span class='text'>(.*?)</span>`).FindStringSubmatch(r.UpdatePortals[0].Body)
+		require.Len(t, m, 2)
+		text = m[1]
+		return text
+	}
+
+	for _, v := range []string{"b", "c"} {
+		assert.Equal(t, v, post("u1", "SetText", historyTestSetTextRequest{Text: v}))
+	}
+	// the state not changed is not recorded
+	assert.Equal(t, "c", post("u1", "SetText", historyTestSetTextRequest{Text: "c"}))
+
+	assert.Equal(t, "b", post("u1", ActionMethodUndo, nil))
+	assert.Equal(t, "a", post("u1", ActionMethodUndo, nil))
+	assert.Equal(t, "", post("u1", ActionMethodUndo, nil))
+	assert.Equal(t, "b", post("u1", ActionMethodRedo, nil))
+
+	// the histories are per user
+	assert.Equal(t, "", post("u2", ActionMethodUndo, nil))
+
+	// the redoable entries are dropped by the new action
+	assert.Equal(t, "x", post("u1", "SetText", historyTestSetTextRequest{Text: "x"}))
+	assert.Equal(t, "", post("u1", ActionMethodRedo, nil))
+
+	// the oldest entries are dropped beyond the limit
+	assert.Equal(t, "c", post("u1", "SetText", historyTestSetTextRequest{Text: "c"}))
+	assert.Equal(t, "d", post("u1", "SetText", historyTestSetTextRequest{Text: "d"}))
+	assert.Equal(t, "c", post("u1", ActionMethodUndo, nil))
+	assert.Equal(t, "x", post("u1", ActionMethodUndo, nil))
+	assert.Equal(t, "b", post("u1", ActionMethodUndo, nil))
+	assert.Equal(t, "", post("u1", ActionMethodUndo, nil))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User", "u1")
+	hist, err := LoadHistory(web.WrapEventContext(context.Background(), &web.EventContext{R: req}), &historyTestEditor{ID: "0"})
+	require.NoError(t, err)
+	assert.False(t, hist.CanUndo())
+	assert.True(t, hist.CanRedo())
+	assert.Len(t, hist.Entries, 3)
+	assert.Equal(t, "SetText", hist.Entries[0].Method)
+	assert.JSONEq(t, `{"id":"0","text":"b"}`, string(hist.Entries[0].Before))
+
+	// the histories are per page
+	page = "/other"
+	assert.Equal(t, "", post("u1", ActionMethodRedo, nil))
+	assert.Equal(t, "y", post("u1", "SetText", historyTestSetTextRequest{Text: "y"}))
+	req = httptest.NewRequest("GET", "/other", nil)
+	req.Header.Set("X-User", "u1")
+	hist, err = LoadHistory(web.WrapEventContext(context.Background(), &web.EventContext{R: req}), &historyTestEditor{ID: "0"})
+	require.NoError(t, err)
+	assert.Len(t, hist.Entries, 1)
+}
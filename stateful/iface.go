@@ -1,6 +1,11 @@
 package stateful
 
-import h "github.com/theplant/htmlgo"
+import (
+	"context"
+
+	"github.com/qor5/web/v3"
+	h "github.com/theplant/htmlgo"
+)
 
 type Identifiable interface {
 	h.HTMLComponent
@@ -17,3 +22,26 @@ func Unwrap(c h.HTMLComponent) h.HTMLComponent {
 	}
 	return c
 }
+
+// The hooks below are optional interfaces of the actionable compos,
+// they are called in the order of OnRestore, BeforeAction, the action method and AfterAction when dispatching an action.
+
+// ActionRestorer is called after the compo is unmarshalled from the action and injected.
+type ActionRestorer interface {
+	OnRestore(ctx context.Context) error
+}
+
+// BeforeActionHook is called before the action method, e.g. for auth and validation, the method is not called if it returns an error.
+type BeforeActionHook interface {
+	BeforeAction(ctx context.Context, method string) error
+}
+
+// AfterActionHook is called after the action method succeeded, e.g. to append reloads or toasts to the response.
+type AfterActionHook interface {
+	AfterAction(ctx context.Context, r *web.EventResponse)
+}
+
+// BeforeRenderHook is called by Actionable before the compo is rendered, both on the page and in the action responses.
+type BeforeRenderHook interface {
+	BeforeRender(ctx context.Context) error
+}
@@ -0,0 +1,30 @@
+// Package harness exposes the functions of stateful which the testutil package needs to act like a browser,
+// they are set by stateful, so they are not part of its API.
+package harness
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// RenderedCompo is a compo rendered by stateful.Actionable
+type RenderedCompo struct {
+	// PortalName is the name of the portal the Identifiable compo is rendered in, it is empty for the others
+	PortalName string
+	// Action is the json of the stateful.Action the compo posts, with the state and the version it is rendered with
+	Action json.RawMessage
+}
+
+var (
+	// ParseRenderedCompos returns the compos rendered by stateful.Actionable in the html in document order
+	ParseRenderedCompos func(body string) ([]*RenderedCompo, error)
+
+	// ApplyPatchScript applies the patches and the versions of the compo in the run script like the browser does,
+	// it returns the state and the version after them.
+	ApplyPatchScript func(runScript string, compoID string, state json.RawMessage, version string) (json.RawMessage, string, error)
+
+	// EncodeQuery encodes the state of compo c to the query like the browser does with the action,
+	// the fields equal to the defaults are left out, see stateful.Action.QueryDefaults.
+	// Only the tags of the store are encoded if it is not empty.
+	EncodeQuery func(c any, state json.RawMessage, defaults map[string]string, store string, current url.Values) (string, error)
+)
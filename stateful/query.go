@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/qor5/web/v3"
 	"github.com/samber/lo"
 	"github.com/sunfmin/reflectutils"
 	"golang.org/x/sync/singleflight"
@@ -22,11 +23,24 @@ type QueryTag struct {
 	Method string   `json:"method,omitempty"`
 	Args   []string `json:"args,omitempty"`
 
-	Cookie bool `json:"cookie,omitempty"`
+	Cookie bool   `json:"cookie,omitempty"`
+	Store  string `json:"store,omitempty"` // the name of the QueryStore, `cookie` is the same as `store:cookie`
+
+	// Default is the value in the query format used when the query is absent,
+	// the values equal to it are left out of the url.
+	Default *string  `json:"default,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	OneOf   []string `json:"oneof,omitempty"`
 
 	path string
 }
 
+// Path returns the field path of the tag in the struct, custom query tag method decoders use it to set the value.
+func (tag QueryTag) Path() string {
+	return tag.path
+}
+
 type QueryTags []QueryTag
 
 const (
@@ -113,7 +127,12 @@ func collectQueryTags(rt reflect.Type, tags *QueryTags) error {
 					tag.Args = vs[1:]
 				}
 			case "cookie":
-				tag.Cookie = true
+				tag.Store = QueryStoreCookie
+			case "store":
+				if len(colons) != 2 || strings.TrimSpace(colons[1]) == "" {
+					return fmt.Errorf("%q field %q query tag store is invalid", rt.String(), structField.Name)
+				}
+				tag.Store = strings.TrimSpace(colons[1])
 			}
 		}
 
@@ -129,10 +148,15 @@ func collectQueryTags(rt reflect.Type, tags *QueryTags) error {
 			}
 		}
 		tag.JsonName = jsonName
+		tag.Cookie = tag.Store == QueryStoreCookie
 		if tag.Name == "" {
 			tag.Name = tag.JsonName
 		}
 
+		if err := parseQueryValidationTags(structField, &tag); err != nil {
+			return fmt.Errorf("%q field %q %w", rt.String(), structField.Name, err)
+		}
+
 		_, index, exists := lo.FindIndexOf(*tags, func(v QueryTag) bool { return v.JsonName == jsonName })
 		if exists {
 			(*tags)[index] = tag
@@ -182,7 +206,20 @@ func ParseQueryTags(v any) (QueryTags, error) {
 }
 
 func (tags QueryTags) CookieTags() QueryTags {
-	return QueryTags(lo.Filter(tags, func(tag QueryTag, _ int) bool { return tag.Cookie }))
+	return tags.StoreTags(QueryStoreCookie)
+}
+
+func (tags QueryTags) StoreTags(store string) QueryTags {
+	return QueryTags(lo.Filter(tags, func(tag QueryTag, _ int) bool { return tag.Store == store }))
+}
+
+// StoreNames returns the sorted names of the stores used by the tags
+func (tags QueryTags) StoreNames() []string {
+	names := lo.Uniq(lo.FilterMap(tags, func(tag QueryTag, _ int) (string, bool) {
+		return tag.Store, tag.Store != ""
+	}))
+	sort.Strings(names)
+	return names
 }
 
 func decodeToStruct(rt reflect.Type, descWithoutUnescape string) (any, error) {
@@ -238,81 +275,110 @@ func (tags QueryTags) Decode(rawQuery string, dest any) (rerr error) {
 		return err
 	}
 
+	var verrs web.ValidationErrors
 	for _, tag := range tags {
-		if tag.Method != "" {
-			method, ok := QueryTagMethodsMap[tag.Method]
-			if !ok {
-				return fmt.Errorf("query tag method %q not found", tag.Method)
-			}
-			if err := method.Decoder(qs, tag, dest); err != nil {
-				return fmt.Errorf("failed to decode %q: %w", tag.Name, err)
-			}
+		if err := decodeQueryTag(qs, tag, dest); err != nil {
+			return err
+		}
+		if !hasQueryKey(qs, tag.Name) {
 			continue
 		}
+		if err := tag.validate(dest, &verrs); err != nil {
+			return err
+		}
+	}
+	if verrs.HaveErrors() {
+		return &verrs
+	}
+	return nil
+}
+
+func decodeQueryTag(qs url.Values, tag QueryTag, dest any) error {
+	if tag.Method != "" {
+		method, ok := LookupQueryTagMethod(tag.Method)
+		if !ok {
+			return fmt.Errorf("query tag method %q not found", tag.Method)
+		}
+		if err := method.Decoder(qs, tag, dest); err != nil {
+			return fmt.Errorf("failed to decode %q: %w", tag.Name, err)
+		}
+		return nil
+	}
 
-		qvs, ok := qs[tag.Name]
-		if !ok || len(qvs) == 0 {
-			continue
+	decoded, err := decodeBracketQuery(qs, tag, dest)
+	if err != nil || decoded {
+		return err
+	}
+
+	// the positional format like `Shanghai_China` is still decoded for the existing urls
+	qvs, ok := qs[tag.Name]
+	if !ok || len(qvs) == 0 {
+		return nil
+	}
+	qv := qvs[len(qvs)-1]
+	if qv == "" {
+		// set zero value if empty?
+		if err := reflectutils.Set(dest, tag.path, qv); err != nil {
+			return fmt.Errorf("failed to set %q to %v: %w", tag.path, dest, err)
 		}
-		qv := qvs[len(qvs)-1]
-		if qv == "" {
-			// set zero value if empty?
-			if err := reflectutils.Set(dest, tag.path, qv); err != nil {
-				return fmt.Errorf("failed to set %q to %v: %w", tag.path, dest, err)
-			}
-			continue
+		return nil
+	}
+
+	rt := reflectutils.GetType(dest, tag.path)
+	switch unwrapPtrType(rt).Kind() {
+	case reflect.Array, reflect.Slice:
+		// the elements are set one by one, so clear the existing ones like the defaults
+		field, err := destField(dest, tag.path)
+		if err != nil {
+			return err
 		}
+		field.Set(reflect.Zero(field.Type()))
 
-		rt := reflectutils.GetType(dest, tag.path)
-		switch unwrapPtrType(rt).Kind() {
-		case reflect.Array, reflect.Slice:
-			elements := strings.Split(qv, ",")
-
-			rtElem := rt.Elem()
-			switch unwrapPtrType(rtElem).Kind() {
-			case reflect.Struct:
-				for i, element := range elements {
-					elem, err := decodeToStruct(rtElem, element)
-					if err != nil {
-						return err
-					}
-
-					path := fmt.Sprintf("%s[%d]", tag.path, i)
-					if err := reflectutils.Set(dest, path, elem); err != nil {
-						return fmt.Errorf("failed to set %q to %v: %w", path, dest, err)
-					}
+		elements := strings.Split(qv, ",")
+
+		rtElem := rt.Elem()
+		switch unwrapPtrType(rtElem).Kind() {
+		case reflect.Struct:
+			for i, element := range elements {
+				elem, err := decodeToStruct(rtElem, element)
+				if err != nil {
+					return err
 				}
-			default:
-				for i, element := range elements {
-					unescape, err := url.QueryUnescape(element)
-					if err != nil {
-						return fmt.Errorf("failed to unescape %q: %w", element, err)
-					}
-					path := fmt.Sprintf("%s[%d]", tag.path, i)
-					if err := reflectutils.Set(dest, path, unescape); err != nil {
-						return fmt.Errorf("failed to set %q to %v: %w", path, dest, err)
-					}
+
+				path := fmt.Sprintf("%s[%d]", tag.path, i)
+				if err := reflectutils.Set(dest, path, elem); err != nil {
+					return fmt.Errorf("failed to set %q to %v: %w", path, dest, err)
 				}
 			}
-		case reflect.Struct:
-			obj, err := decodeToStruct(rt, qv)
-			if err != nil {
-				return err
-			}
-			if err := reflectutils.Set(dest, tag.path, obj); err != nil {
-				return fmt.Errorf("failed to set %q to %v: %w", tag.path, dest, err)
-			}
 		default:
-			unescape, err := url.QueryUnescape(qv)
-			if err != nil {
-				return fmt.Errorf("failed to unescape %q: %w", qv, err)
-			}
-			if err := reflectutils.Set(dest, tag.path, unescape); err != nil {
-				return fmt.Errorf("failed to set %q to %v: %w", tag.path, dest, err)
+			for i, element := range elements {
+				unescape, err := url.QueryUnescape(element)
+				if err != nil {
+					return fmt.Errorf("failed to unescape %q: %w", element, err)
+				}
+				path := fmt.Sprintf("%s[%d]", tag.path, i)
+				if err := reflectutils.Set(dest, path, unescape); err != nil {
+					return fmt.Errorf("failed to set %q to %v: %w", path, dest, err)
+				}
 			}
 		}
+	case reflect.Struct:
+		obj, err := decodeToStruct(rt, qv)
+		if err != nil {
+			return err
+		}
+		if err := reflectutils.Set(dest, tag.path, obj); err != nil {
+			return fmt.Errorf("failed to set %q to %v: %w", tag.path, dest, err)
+		}
+	default:
+		unescape, err := url.QueryUnescape(qv)
+		if err != nil {
+			return fmt.Errorf("failed to unescape %q: %w", qv, err)
+		}
+		if err := reflectutils.Set(dest, tag.path, unescape); err != nil {
+			return fmt.Errorf("failed to set %q to %v: %w", tag.path, dest, err)
+		}
 	}
-
 	return nil
 }
 
@@ -380,12 +446,15 @@ func parseJsonTags(rt reflect.Type) (map[string]string, error) {
 }
 
 // tags: jsonName => path
+// Like encoding/json, the fields of the outer struct take precedence over the embedded ones,
+// and the unexported or ignored fields are skipped.
 func collectJsonTags(rt reflect.Type, tags map[string]string) error {
 	rt = unwrapPtrType(rt)
 	if rt.Kind() != reflect.Struct {
 		return fmt.Errorf("%q expected struct, got %v", rt.String(), rt.Kind())
 	}
 
+	embeddedTags := map[string]string{}
 	for i := 0; i < rt.NumField(); i++ {
 		structField := rt.Field(i)
 
@@ -402,7 +471,7 @@ func collectJsonTags(rt reflect.Type, tags map[string]string) error {
 		if embedded {
 			rtStructField := unwrapPtrType(structField.Type)
 			if rtStructField.Kind() == reflect.Struct {
-				if err := collectJsonTags(rtStructField, tags); err != nil {
+				if err := collectJsonTags(rtStructField, embeddedTags); err != nil {
 					return err
 				}
 			}
@@ -410,7 +479,7 @@ func collectJsonTags(rt reflect.Type, tags map[string]string) error {
 		}
 
 		if !structField.IsExported() {
-			return fmt.Errorf("%q field %q is not exported", rt.String(), structField.Name)
+			continue
 		}
 
 		name := structField.Name
@@ -418,15 +487,19 @@ func collectJsonTags(rt reflect.Type, tags map[string]string) error {
 		if ok {
 			name = strings.Split(jsonTag, ",")[0]
 			if name == "-" {
-				return fmt.Errorf("%q field %q is ignored by json", rt.String(), structField.Name)
+				continue
 			}
 			if name == "" {
 				name = structField.Name
 			}
 		}
-		// TODO: It's not quite right here, there's no guarantee that the outer layer has a higher priority, the embedded one has a lower priority
 		tags[name] = structField.Name
 	}
+	for name, path := range embeddedTags {
+		if _, ok := tags[name]; !ok {
+			tags[name] = path
+		}
+	}
 	return nil
 }
 
@@ -473,57 +546,3 @@ func IsRawQuerySubset(sup, sub string) bool {
 
 	return IsQuerySubset(supValues, subValues)
 }
-
-type QueryTagMethod struct {
-	Name    string                                         `json:"name"`
-	Encoder string                                         `json:"encoder"` // js
-	Decoder func(qs url.Values, tag QueryTag, v any) error `json:"-"`       // go
-}
-
-// tag example: `query:";method=bare,f_"`
-// arg0: the prefix of the query key
-var QueryTagMethodBare = &QueryTagMethod{
-	Name: "bare",
-	Encoder: `({ value, queries, tag }) => {
-		if (value) {
-			value.split('&').forEach((query) => {
-				queries.push(query)
-			})
-		}
-	}`,
-	Decoder: func(qs url.Values, tag QueryTag, v any) error {
-		if len(tag.Args) < 1 {
-			return fmt.Errorf("bare query tag method requires at least one argument")
-		}
-		bareQuery := make(url.Values)
-		prefix := tag.Args[0]
-		for k, vs := range qs {
-			if strings.HasPrefix(k, prefix) {
-				for _, v := range vs {
-					unescaped, err := url.QueryUnescape(v)
-					if err != nil {
-						return fmt.Errorf("failed to unescape %q: %w", v, err)
-					}
-					bareQuery.Add(k, unescaped)
-				}
-			}
-		}
-		reflectutils.Set(v, tag.path, bareQuery.Encode())
-		return nil
-	},
-}
-
-var QueryTagMethods = []*QueryTagMethod{
-	QueryTagMethodBare,
-}
-
-var QueryTagMethodsMap = func() map[string]*QueryTagMethod {
-	m := map[string]*QueryTagMethod{}
-	for _, v := range QueryTagMethods {
-		if _, ok := m[v.Name]; ok {
-			panic(fmt.Sprintf("duplicate query tag method %q", v.Name))
-		}
-		m[v.Name] = v
-	}
-	return m
-}()
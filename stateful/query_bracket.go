@@ -0,0 +1,303 @@
+package stateful
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maps, structs and slices of structs are encoded with the bracket notation which is compatible with qs,
+// e.g. filter[status]=active&filter[tags]=a,b&order_bys[0][field]=name
+// Struct fields are addressed by their json names, so adding fields does not break the existing urls.
+// Slices of scalars are still comma separated.
+
+// queryNode is either a leaf value or children keyed by the bracket segment
+type queryNode struct {
+	value    *string // not unescaped yet, so that the escaped commas can be kept
+	children map[string]*queryNode
+	order    []string // keys of children in the order of appearance
+}
+
+func (n *queryNode) child(key string) *queryNode {
+	if n.children == nil {
+		n.children = map[string]*queryNode{}
+	}
+	c, ok := n.children[key]
+	if !ok {
+		c = &queryNode{}
+		n.children[key] = c
+		n.order = append(n.order, key)
+	}
+	return c
+}
+
+// parseBracketSegments parses `[a][b][]` to ["a", "b", ""]
+func parseBracketSegments(s string) ([]string, error) {
+	var segments []string
+	for s != "" {
+		if s[0] != '[' {
+			return nil, fmt.Errorf("invalid bracket notation %q", s)
+		}
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid bracket notation %q", s)
+		}
+		segments = append(segments, s[1:end])
+		s = s[end+1:]
+	}
+	return segments, nil
+}
+
+// parseBracketQuery collects the keys like name[...] into a tree, it returns nil if there is no such key.
+func parseBracketQuery(qs url.Values, name string) (*queryNode, error) {
+	prefix := name + "["
+	keys := make([]string, 0)
+	for k := range qs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sort.Strings(keys)
+
+	root := &queryNode{}
+	for _, k := range keys {
+		segments, err := parseBracketSegments(k[len(name):])
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range qs[k] {
+			value := raw
+			node := root
+			for _, seg := range segments {
+				if node.value != nil {
+					return nil, fmt.Errorf("conflicting query key %q", k)
+				}
+				if seg == "" {
+					// name[]=a&name[]=b appends
+					seg = strconv.Itoa(len(node.order))
+				}
+				node = node.child(seg)
+			}
+			if node.children != nil {
+				return nil, fmt.Errorf("conflicting query key %q", k)
+			}
+			node.value = &value
+		}
+	}
+	return root, nil
+}
+
+// decodeQueryNode sets the node to rv which must be settable
+func decodeQueryNode(rv reflect.Value, node *queryNode) error {
+	if node.value != nil && *node.value == "" {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeQueryNode(rv.Elem(), node)
+	}
+
+	if node.value != nil {
+		if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && textUnmarshaler(rv) == nil {
+			return decodeCommaSeparated(rv, *node.value)
+		}
+		value, err := url.QueryUnescape(*node.value)
+		if err != nil {
+			return fmt.Errorf("failed to unescape %q: %w", *node.value, err)
+		}
+		return setQueryScalar(rv, value)
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		m := reflect.New(reflect.TypeOf(map[string]any{})).Elem()
+		if err := decodeQueryNode(m, node); err != nil {
+			return err
+		}
+		rv.Set(m)
+		return nil
+	case reflect.Struct:
+		jsonTags, err := parseJsonTags(rv.Type())
+		if err != nil {
+			return err
+		}
+		for _, key := range node.order {
+			fieldName, ok := jsonTags[key]
+			if !ok {
+				// ignore the unknown fields like encoding/json
+				continue
+			}
+			field, err := fieldByNameAlloc(rv, fieldName)
+			if err != nil {
+				return err
+			}
+			if err := decodeQueryNode(field, node.children[key]); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for _, key := range node.order {
+			kv := reflect.New(rv.Type().Key()).Elem()
+			if err := setQueryScalar(kv, key); err != nil {
+				return err
+			}
+			ev := reflect.New(rv.Type().Elem()).Elem()
+			if err := decodeQueryNode(ev, node.children[key]); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			rv.SetMapIndex(kv, ev)
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		indexes := make([]int, 0, len(node.order))
+		for _, key := range node.order {
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 {
+				return fmt.Errorf("invalid index %q", key)
+			}
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		// sparse indexes are compacted like qs does
+		if rv.Kind() == reflect.Slice {
+			rv.Set(reflect.MakeSlice(rv.Type(), len(indexes), len(indexes)))
+		} else if len(indexes) > rv.Len() {
+			return fmt.Errorf("too many elements for %v", rv.Type())
+		}
+		for i, index := range indexes {
+			if err := decodeQueryNode(rv.Index(i), node.children[strconv.Itoa(index)]); err != nil {
+				return fmt.Errorf("%d: %w", index, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("can not decode nested query to %v", rv.Type())
+	}
+}
+
+func decodeCommaSeparated(rv reflect.Value, s string) error {
+	elements := strings.Split(s, ",")
+	if rv.Kind() == reflect.Slice {
+		rv.Set(reflect.MakeSlice(rv.Type(), len(elements), len(elements)))
+	} else if len(elements) > rv.Len() {
+		return fmt.Errorf("too many elements for %v", rv.Type())
+	}
+	for i, element := range elements {
+		if err := decodeQueryNode(rv.Index(i), &queryNode{value: &element}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByNameAlloc is like FieldByName but allocates the nil embedded pointers on the way
+func fieldByNameAlloc(rv reflect.Value, name string) (reflect.Value, error) {
+	sf, ok := rv.Type().FieldByName(name)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("field %q not found in %v", name, rv.Type())
+	}
+	for i, index := range sf.Index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(index)
+	}
+	return rv, nil
+}
+
+func textUnmarshaler(rv reflect.Value) encoding.TextUnmarshaler {
+	if !rv.CanAddr() {
+		return nil
+	}
+	u, _ := rv.Addr().Interface().(encoding.TextUnmarshaler)
+	return u
+}
+
+func setQueryScalar(rv reflect.Value, s string) error {
+	if u := textUnmarshaler(rv); u != nil {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		rv.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(s, 10, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetFloat(v)
+	case reflect.Interface:
+		rv.Set(reflect.ValueOf(s))
+	default:
+		return fmt.Errorf("can not set %q to %v", s, rv.Type())
+	}
+	return nil
+}
+
+func decodeBracketQuery(qs url.Values, tag QueryTag, dest any) (bool, error) {
+	node, err := parseBracketQuery(qs, tag.Name)
+	if err != nil || node == nil {
+		return false, err
+	}
+	field, err := destField(dest, tag.path)
+	if err != nil {
+		return true, err
+	}
+	// the url describes the whole value, so start from zero
+	v := reflect.New(field.Type()).Elem()
+	if err := decodeQueryNode(v, node); err != nil {
+		return true, fmt.Errorf("failed to decode %q: %w", tag.Name, err)
+	}
+	field.Set(v)
+	return true, nil
+}
+
+// hasQueryKey reports whether the query has the name or the bracket keys like name[...]
+func hasQueryKey(qs url.Values, name string) bool {
+	if _, ok := qs[name]; ok {
+		return true
+	}
+	prefix := name + "["
+	for k := range qs {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package stateful
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// encode encodes the state of the compo to the query like encodeObjectToQuery of corejs does in the browser,
+// it is the reverse of Decode, e.g. to post the actions of the testutil package.
+// The fields equal to the defaults are left out, see Action.QueryDefaults.
+// The encoders of the tag methods are js, so the values of those tags are kept from the current query.
+func (tags QueryTags) encode(v any, defaults map[string]string, current url.Values) (string, error) {
+	obj, err := queryObject(v)
+	if err != nil {
+		return "", err
+	}
+
+	var queries []string
+	for _, tag := range tags {
+		value, ok := obj[tag.JsonName]
+		if !ok || isDefaultQueryValue(tag.queryDefault(defaults), value) {
+			continue
+		}
+
+		if tag.Method != "" {
+			keys := make([]string, 0, len(current))
+			for k := range current {
+				if k == tag.Name || strings.HasPrefix(k, tag.Name+"[") {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				for _, v := range current[k] {
+					queries = append(queries, encodeURIComponent(k)+"="+encodeURIComponent(v))
+				}
+			}
+			continue
+		}
+
+		key := encodeURIComponent(tag.Name)
+		if tag.Omitempty && isFalsy(value) {
+			continue
+		}
+		switch v := value.(type) {
+		case nil:
+			queries = append(queries, key+"=")
+		case []any:
+			if tag.Omitempty && len(v) == 0 {
+				continue
+			}
+			if hasObject(v) {
+				queries = appendNestedQuery(queries, key, v)
+				continue
+			}
+			queries = append(queries, key+"="+joinQueryArray(v))
+		case map[string]any:
+			queries = appendNestedQuery(queries, key, v)
+		default:
+			queries = append(queries, key+"="+encodeURIComponent(queryString(v)))
+		}
+	}
+	return strings.Join(queries, "&"), nil
+}
+
+func appendNestedQuery(queries []string, prefix string, value any) []string {
+	switch v := value.(type) {
+	case nil:
+		return append(queries, prefix+"=")
+	case []any:
+		if !hasObject(v) {
+			return append(queries, prefix+"="+joinQueryArray(v))
+		}
+		for i, item := range v {
+			queries = appendNestedQuery(queries, fmt.Sprintf("%s[%d]", prefix, i), item)
+		}
+		return queries
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			queries = appendNestedQuery(queries, prefix+"["+encodeURIComponent(k)+"]", v[k])
+		}
+		return queries
+	default:
+		return append(queries, prefix+"="+encodeURIComponent(queryString(v)))
+	}
+}
+
+// queryObject returns the json object of the state of the compo, v is the compo or its json
+func queryObject(v any) (map[string]any, error) {
+	state, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if state, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var obj map[string]any
+	dec := json.NewDecoder(bytes.NewReader(state))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	return obj, nil
+}
+
+// initialQueries returns the values of the fields with the default tag which differ from their defaults,
+// e.g. set by the caller of SyncQuery before the query is decoded, see Action.QueryDefaults
+func (tags QueryTags) initialQueries(v any) (map[string]string, error) {
+	obj, err := queryObject(v)
+	if err != nil {
+		return nil, err
+	}
+	var defaults map[string]string
+	for _, tag := range tags {
+		value, ok := obj[tag.JsonName]
+		if !ok || tag.Default == nil || isDefaultQueryValue(tag.Default, value) {
+			continue
+		}
+		query, ok := defaultQueryString(value)
+		if !ok {
+			continue
+		}
+		if defaults == nil {
+			defaults = map[string]string{}
+		}
+		defaults[tag.Name] = query
+	}
+	return defaults, nil
+}
+
+// queryDefault returns the value the field is left out of the query with,
+// it is the initial value of the compo if it differs from the default tag
+func (tag QueryTag) queryDefault(defaults map[string]string) *string {
+	if v, ok := defaults[tag.Name]; ok {
+		return &v
+	}
+	return tag.Default
+}
+
+// defaultQueryString returns the value in the format of the default tag, the nulls and the objects have no such format
+func defaultQueryString(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil, map[string]any:
+		return "", false
+	case []any:
+		if hasObject(v) {
+			return "", false
+		}
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = queryString(item)
+		}
+		return strings.Join(items, ","), true
+	default:
+		return queryString(v), true
+	}
+}
+
+func isDefaultQueryValue(def *string, value any) bool {
+	if def == nil {
+		return false
+	}
+	query, ok := defaultQueryString(value)
+	return ok && query == *def
+}
+
+func isObject(v any) bool {
+	switch v.(type) {
+	case []any, map[string]any:
+		return true
+	}
+	return false
+}
+
+func hasObject(vs []any) bool {
+	for _, v := range vs {
+		if isObject(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFalsy follows the falsy values of js
+func isFalsy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case json.Number:
+		f, err := v.Float64()
+		return err == nil && f == 0
+	}
+	return false
+}
+
+func queryString(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func joinQueryArray(vs []any) string {
+	items := make([]string, len(vs))
+	for i, v := range vs {
+		items[i] = encodeURIComponent(queryString(v))
+	}
+	return strings.Join(items, ",")
+}
+
+var uriComponentUnescaper = strings.NewReplacer("+", "%20", "%21", "!", "%27", "'", "%28", "(", "%29", ")", "%2A", "*", "%7E", "~")
+
+func encodeURIComponent(s string) string {
+	return uriComponentUnescaper.Replace(url.QueryEscape(s))
+}
@@ -0,0 +1,71 @@
+package stateful
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryTagsEncode(t *testing.T) {
+	type nested struct {
+		Status string   `json:"status"`
+		IDs    []int    `json:"ids"`
+		Note   *string  `json:"note"`
+		Names  []string `json:"names"`
+	}
+	type state struct {
+		Keyword string            `json:"keyword" query:"keyword,omitempty"`
+		Page    int               `json:"page" query:"page" default:"1"`
+		Filter  nested            `json:"filter" query:"filter"`
+		Extra   map[string]string `json:"extra" query:"extra,omitempty"`
+		Since   string            `json:"since" query:"since;method:json"`
+	}
+	tags, err := ParseQueryTags(&state{})
+	require.NoError(t, err)
+
+	v := &state{
+		Keyword: "a b(c)",
+		Page:    1,
+		Filter:  nested{Status: "active", IDs: []int{1, 2}},
+		Extra:   map[string]string{"z": "1", "a": "2"},
+	}
+	query, err := tags.encode(v, nil, url.Values{"since": {`"2024"`}, "other": {"x"}})
+	require.NoError(t, err)
+	assert.Equal(t, `keyword=a%20b(c)&filter[ids]=1,2&filter[names]=&filter[note]=&filter[status]=active&extra[a]=2&extra[z]=1&since=%222024%22`, query)
+
+	raw, err := tags.encode(json.RawMessage(PrettyJSONString(v)), nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `keyword=a%20b(c)&filter[ids]=1,2&filter[names]=&filter[note]=&filter[status]=active&extra[a]=2&extra[z]=1`, raw)
+
+	// the encoded query is decoded to the same state
+	var decoded state
+	require.NoError(t, tags.Decode(raw, &decoded))
+	// the default page is left out of the query, and the empty names are decoded as nil
+	decoded.Page = 1
+	decoded.Filter.Names = nil
+	assert.Equal(t, v, &decoded)
+}
+
+func TestQueryTagsEncodeInitialDefaults(t *testing.T) {
+	tags, err := ParseQueryTags(&syncQueryTestCompo{})
+	require.NoError(t, err)
+
+	defaults, err := tags.initialQueries(&syncQueryTestCompo{PageSize: 20, OrderBy: "name"})
+	require.NoError(t, err)
+	assert.Nil(t, defaults)
+
+	// the page size set by the caller is left out instead of the default of the tag
+	defaults, err = tags.initialQueries(&syncQueryTestCompo{PageSize: 50, OrderBy: "name"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"page_size": "50"}, defaults)
+
+	query, err := tags.encode(&syncQueryTestCompo{PageSize: 20, OrderBy: "name"}, defaults, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "page_size=20", query)
+	query, err = tags.encode(&syncQueryTestCompo{PageSize: 50, OrderBy: "age"}, defaults, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "order_by=age", query)
+}
@@ -0,0 +1,471 @@
+package stateful
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/sunfmin/reflectutils"
+)
+
+type QueryTagMethod struct {
+	Name    string                                         `json:"name"`
+	Encoder string                                         `json:"encoder"` // js
+	Decoder func(qs url.Values, tag QueryTag, v any) error `json:"-"`       // go
+}
+
+var (
+	queryTagMethodsMu sync.RWMutex
+	// queryTagMethods are the registered methods by name, in the order of registration
+	queryTagMethods = map[string]*QueryTagMethod{}
+	queryTagNames   []string
+)
+
+// Deprecated: use RegisterQueryTagMethod to add methods and RegisteredQueryTagMethods to list them.
+// The registered methods are appended to it, and the methods appended to it directly, e.g. in the init of a package,
+// are still found by Decode and Actionable unless a method of the same name is registered.
+var QueryTagMethods []*QueryTagMethod
+
+// RegisterQueryTagMethod makes the methods available for both Decode and the JS encoders emitted by Actionable.
+// It panics if a method is invalid or its name is already registered.
+func RegisterQueryTagMethod(vs ...*QueryTagMethod) {
+	queryTagMethodsMu.Lock()
+	defer queryTagMethodsMu.Unlock()
+	for _, v := range vs {
+		if v == nil || strings.TrimSpace(v.Name) == "" {
+			panic("query tag method name is required")
+		}
+		if v.Encoder == "" || v.Decoder == nil {
+			panic(fmt.Sprintf("query tag method %q requires both encoder and decoder", v.Name))
+		}
+		if _, ok := lookupQueryTagMethod(v.Name); ok {
+			panic(fmt.Sprintf("query tag method %q already registered", v.Name))
+		}
+		queryTagMethods[v.Name] = v
+		queryTagNames = append(queryTagNames, v.Name)
+		QueryTagMethods = append(QueryTagMethods, v)
+	}
+}
+
+func LookupQueryTagMethod(name string) (*QueryTagMethod, bool) {
+	queryTagMethodsMu.RLock()
+	defer queryTagMethodsMu.RUnlock()
+	return lookupQueryTagMethod(name)
+}
+
+func lookupQueryTagMethod(name string) (*QueryTagMethod, bool) {
+	if v, ok := queryTagMethods[name]; ok {
+		return v, true
+	}
+	return lo.Find(QueryTagMethods, func(v *QueryTagMethod) bool {
+		return v != nil && v.Name == name
+	})
+}
+
+// RegisteredQueryTagMethods returns all registered methods in the order of registration,
+// followed by the ones only appended to the deprecated QueryTagMethods.
+func RegisteredQueryTagMethods() []*QueryTagMethod {
+	queryTagMethodsMu.RLock()
+	defer queryTagMethodsMu.RUnlock()
+	vs := lo.Map(queryTagNames, func(name string, _ int) *QueryTagMethod {
+		return queryTagMethods[name]
+	})
+	for _, v := range QueryTagMethods {
+		if v == nil {
+			continue
+		}
+		if _, ok := queryTagMethods[v.Name]; !ok {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+func init() {
+	RegisterQueryTagMethod(
+		QueryTagMethodBare,
+		QueryTagMethodJSON,
+		QueryTagMethodBase64JSON,
+		QueryTagMethodTime,
+		QueryTagMethodDate,
+		QueryTagMethodRange,
+		QueryTagMethodEnum,
+	)
+}
+
+// lastQueryValue returns the unescaped last value of the tag name
+func lastQueryValue(qs url.Values, tag QueryTag) (string, bool, error) {
+	qvs, ok := qs[tag.Name]
+	if !ok || len(qvs) == 0 {
+		return "", false, nil
+	}
+	qv, err := url.QueryUnescape(qvs[len(qvs)-1])
+	if err != nil {
+		return "", false, fmt.Errorf("failed to unescape %q: %w", qvs[len(qvs)-1], err)
+	}
+	return qv, true, nil
+}
+
+func setZeroValue(v any, tag QueryTag) error {
+	rt := reflectutils.GetType(v, tag.path)
+	if err := reflectutils.Set(v, tag.path, reflect.New(rt).Elem().Interface()); err != nil {
+		return fmt.Errorf("failed to set %q to %v: %w", tag.path, v, err)
+	}
+	return nil
+}
+
+// jsQueryValueIsEmpty follows the omitempty rule of encoding/json
+const jsQueryValueIsEmpty = `(v) => v === undefined || v === null || v === '' || v === 0 || v === false ||
+		(Array.isArray(v) && v.length === 0) || (typeof v === 'object' && Object.keys(v).length === 0)`
+
+// tag example: `query:";method:bare,f_"`
+// arg0: the prefix of the query key
+var QueryTagMethodBare = &QueryTagMethod{
+	Name: "bare",
+	Encoder: `({ value, queries, tag }) => {
+		if (value) {
+			value.split('&').forEach((query) => {
+				queries.push(query)
+			})
+		}
+	}`,
+	Decoder: func(qs url.Values, tag QueryTag, v any) error {
+		if len(tag.Args) < 1 {
+			return fmt.Errorf("bare query tag method requires at least one argument")
+		}
+		bareQuery := make(url.Values)
+		prefix := tag.Args[0]
+		for k, vs := range qs {
+			if strings.HasPrefix(k, prefix) {
+				for _, v := range vs {
+					unescaped, err := url.QueryUnescape(v)
+					if err != nil {
+						return fmt.Errorf("failed to unescape %q: %w", v, err)
+					}
+					bareQuery.Add(k, unescaped)
+				}
+			}
+		}
+		reflectutils.Set(v, tag.path, bareQuery.Encode())
+		return nil
+	},
+}
+
+func decodeJSONQueryValue(v any, tag QueryTag, data []byte) error {
+	rt := reflectutils.GetType(v, tag.path)
+	ptr := reflect.New(rt)
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return fmt.Errorf("failed to unmarshal %q: %w", string(data), err)
+	}
+	if err := reflectutils.Set(v, tag.path, ptr.Elem().Interface()); err != nil {
+		return fmt.Errorf("failed to set %q to %v: %w", tag.path, v, err)
+	}
+	return nil
+}
+
+// tag example: `query:"filter;method:json"`
+var QueryTagMethodJSON = &QueryTagMethod{
+	Name: "json",
+	Encoder: fmt.Sprintf(`({ value, queries, tag }) => {
+		const isEmpty = %s
+		if (tag.omitempty && isEmpty(value)) {
+			return
+		}
+		queries.push(encodeURIComponent(tag.name) + '=' + encodeURIComponent(JSON.stringify(value)))
+	}`, jsQueryValueIsEmpty),
+	Decoder: func(qs url.Values, tag QueryTag, v any) error {
+		qv, ok, err := lastQueryValue(qs, tag)
+		if err != nil || !ok {
+			return err
+		}
+		if qv == "" {
+			return setZeroValue(v, tag)
+		}
+		return decodeJSONQueryValue(v, tag, []byte(qv))
+	},
+}
+
+// tag example: `query:"filter;method:base64json"`
+// the value is the unpadded base64url encoding of the JSON
+var QueryTagMethodBase64JSON = &QueryTagMethod{
+	Name: "base64json",
+	Encoder: fmt.Sprintf(`({ value, queries, tag }) => {
+		const isEmpty = %s
+		if (tag.omitempty && isEmpty(value)) {
+			return
+		}
+		let binary = ''
+		new TextEncoder().encode(JSON.stringify(value)).forEach((b) => {
+			binary += String.fromCharCode(b)
+		})
+		const encoded = btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+		queries.push(encodeURIComponent(tag.name) + '=' + encoded)
+	}`, jsQueryValueIsEmpty),
+	Decoder: func(qs url.Values, tag QueryTag, v any) error {
+		qv, ok, err := lastQueryValue(qs, tag)
+		if err != nil || !ok {
+			return err
+		}
+		if qv == "" {
+			return setZeroValue(v, tag)
+		}
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(qv, "="))
+		if err != nil {
+			return fmt.Errorf("failed to decode base64 %q: %w", qv, err)
+		}
+		return decodeJSONQueryValue(v, tag, data)
+	},
+}
+
+const jsZeroTime = `0001-01-01T00:00:00Z`
+
+func decodeTimeQueryValue(qs url.Values, tag QueryTag, v any, parse func(s string) (time.Time, error), format func(t time.Time) string) error {
+	qv, ok, err := lastQueryValue(qs, tag)
+	if err != nil || !ok {
+		return err
+	}
+	if qv == "" {
+		return setZeroValue(v, tag)
+	}
+	t, err := parse(qv)
+	if err != nil {
+		return fmt.Errorf("failed to parse time %q: %w", qv, err)
+	}
+
+	var value any
+	switch unwrapPtrType(reflectutils.GetType(v, tag.path)) {
+	case reflect.TypeOf(time.Time{}):
+		value = t
+	case reflect.TypeOf(""):
+		value = format(t)
+	default:
+		return fmt.Errorf("field %q must be time.Time or string", tag.path)
+	}
+	if err := reflectutils.Set(v, tag.path, value); err != nil {
+		return fmt.Errorf("failed to set %q to %v: %w", tag.path, v, err)
+	}
+	return nil
+}
+
+// tag example: `query:"since;method:time"`
+// the value is formatted as RFC3339
+var QueryTagMethodTime = &QueryTagMethod{
+	Name: "time",
+	Encoder: fmt.Sprintf(`({ value, queries, tag }) => {
+		if (!value || value === '%s') {
+			if (!tag.omitempty) {
+				queries.push(encodeURIComponent(tag.name) + '=')
+			}
+			return
+		}
+		queries.push(encodeURIComponent(tag.name) + '=' + encodeURIComponent(value))
+	}`, jsZeroTime),
+	Decoder: func(qs url.Values, tag QueryTag, v any) error {
+		return decodeTimeQueryValue(qs, tag, v,
+			func(s string) (time.Time, error) { return time.Parse(time.RFC3339Nano, s) },
+			func(t time.Time) string { return t.Format(time.RFC3339Nano) },
+		)
+	},
+}
+
+// tag example: `query:"day;method:date,Asia/Tokyo"`
+// arg0: optional IANA time zone of the date, defaults to the offset of the value itself when encoding and UTC when decoding
+var QueryTagMethodDate = &QueryTagMethod{
+	Name: "date",
+	Encoder: fmt.Sprintf(`({ value, queries, tag }) => {
+		if (!value || value === '%s') {
+			if (!tag.omitempty) {
+				queries.push(encodeURIComponent(tag.name) + '=')
+			}
+			return
+		}
+		let date = value.substring(0, 10)
+		if (tag.args && tag.args.length > 0 && value.length > 10) {
+			date = new Date(value).toLocaleDateString('en-CA', { timeZone: tag.args[0] })
+		}
+		queries.push(encodeURIComponent(tag.name) + '=' + encodeURIComponent(date))
+	}`, jsZeroTime),
+	Decoder: func(qs url.Values, tag QueryTag, v any) error {
+		loc := time.UTC
+		if len(tag.Args) > 0 {
+			var err error
+			loc, err = time.LoadLocation(tag.Args[0])
+			if err != nil {
+				return fmt.Errorf("failed to load location %q: %w", tag.Args[0], err)
+			}
+		}
+		return decodeTimeQueryValue(qs, tag, v,
+			func(s string) (time.Time, error) { return time.ParseInLocation(time.DateOnly, s, loc) },
+			func(t time.Time) string { return t.Format(time.DateOnly) },
+		)
+	},
+}
+
+// QueryRange is the value of the range query tag method, nil means unbounded.
+type QueryRange[T any] struct {
+	Min *T `json:"min,omitempty"`
+	Max *T `json:"max,omitempty"`
+}
+
+const queryRangeSeparator = ".."
+
+func decodeQueryRange(rt reflect.Type, s string) (any, error) {
+	minStr, maxStr, ok := strings.Cut(s, queryRangeSeparator)
+	if !ok {
+		return nil, fmt.Errorf("invalid range %q, expected min..max", s)
+	}
+	jsonTags, err := parseJsonTags(rt)
+	if err != nil {
+		return nil, err
+	}
+	ptr := reflect.New(unwrapPtrType(rt)).Interface()
+	for jsonName, raw := range map[string]string{"min": minStr, "max": maxStr} {
+		path, ok := jsonTags[jsonName]
+		if !ok {
+			return nil, fmt.Errorf("%q has no field with json name %q", rt.String(), jsonName)
+		}
+		if raw == "" {
+			continue
+		}
+		unescaped, err := url.QueryUnescape(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unescape %q: %w", raw, err)
+		}
+		if err := reflectutils.Set(ptr, path, unescaped); err != nil {
+			return nil, fmt.Errorf("failed to set %q to %v: %w", path, ptr, err)
+		}
+	}
+	if rt.Kind() == reflect.Ptr {
+		return ptr, nil
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), nil
+}
+
+// tag example: `query:"price;method:range"`
+// the field is a QueryRange (or a struct with min and max json fields) or a slice of it, e.g. price=10..20,100..
+var QueryTagMethodRange = &QueryTagMethod{
+	Name: "range",
+	Encoder: `({ value, queries, tag }) => {
+		const isSet = (v) => v !== undefined && v !== null
+		const ranges = (Array.isArray(value) ? value : [value]).filter(
+			(r) => r && (isSet(r.min) || isSet(r.max))
+		)
+		if (ranges.length === 0) {
+			if (!tag.omitempty) {
+				queries.push(encodeURIComponent(tag.name) + '=')
+			}
+			return
+		}
+		const encoded = ranges
+			.map((r) => encodeURIComponent(r.min ?? '') + '..' + encodeURIComponent(r.max ?? ''))
+			.join(',')
+		queries.push(encodeURIComponent(tag.name) + '=' + encoded)
+	}`,
+	Decoder: func(qs url.Values, tag QueryTag, v any) error {
+		qvs, ok := qs[tag.Name]
+		if !ok || len(qvs) == 0 {
+			return nil
+		}
+		qv := qvs[len(qvs)-1]
+		if qv == "" {
+			return setZeroValue(v, tag)
+		}
+
+		rt := reflectutils.GetType(v, tag.path)
+		switch unwrapPtrType(rt).Kind() {
+		case reflect.Struct:
+			r, err := decodeQueryRange(rt, qv)
+			if err != nil {
+				return err
+			}
+			if err := reflectutils.Set(v, tag.path, r); err != nil {
+				return fmt.Errorf("failed to set %q to %v: %w", tag.path, v, err)
+			}
+		case reflect.Slice:
+			if err := setZeroValue(v, tag); err != nil {
+				return err
+			}
+			for i, element := range strings.Split(qv, ",") {
+				r, err := decodeQueryRange(unwrapPtrType(rt).Elem(), element)
+				if err != nil {
+					return err
+				}
+				path := fmt.Sprintf("%s[%d]", tag.path, i)
+				if err := reflectutils.Set(v, path, r); err != nil {
+					return fmt.Errorf("failed to set %q to %v: %w", path, v, err)
+				}
+			}
+		default:
+			return fmt.Errorf("field %q must be a range struct or a slice of it", tag.path)
+		}
+		return nil
+	},
+}
+
+// tag example: `query:"status;method:enum,active,archived"`
+// args: the allowed values, the field can be a scalar or a slice which is comma separated
+var QueryTagMethodEnum = &QueryTagMethod{
+	Name: "enum",
+	Encoder: fmt.Sprintf(`({ value, queries, tag }) => {
+		const isEmpty = %s
+		if (tag.omitempty && isEmpty(value)) {
+			return
+		}
+		const values = Array.isArray(value) ? value : [value]
+		queries.push(
+			encodeURIComponent(tag.name) + '=' + values.map((v) => encodeURIComponent(v ?? '')).join(',')
+		)
+	}`, jsQueryValueIsEmpty),
+	Decoder: func(qs url.Values, tag QueryTag, v any) error {
+		if len(tag.Args) == 0 {
+			return fmt.Errorf("enum query tag method requires the allowed values as arguments")
+		}
+		qvs, ok := qs[tag.Name]
+		if !ok || len(qvs) == 0 {
+			return nil
+		}
+		qv := qvs[len(qvs)-1]
+		if qv == "" {
+			return setZeroValue(v, tag)
+		}
+
+		elements := []string{qv}
+		isSlice := unwrapPtrType(reflectutils.GetType(v, tag.path)).Kind() == reflect.Slice
+		if isSlice {
+			elements = strings.Split(qv, ",")
+		}
+		for i, element := range elements {
+			unescaped, err := url.QueryUnescape(element)
+			if err != nil {
+				return fmt.Errorf("failed to unescape %q: %w", element, err)
+			}
+			if !lo.Contains(tag.Args, unescaped) {
+				return fmt.Errorf("invalid value %q, expected one of %v", unescaped, tag.Args)
+			}
+			elements[i] = unescaped
+		}
+
+		if !isSlice {
+			if err := reflectutils.Set(v, tag.path, elements[0]); err != nil {
+				return fmt.Errorf("failed to set %q to %v: %w", tag.path, v, err)
+			}
+			return nil
+		}
+		if err := setZeroValue(v, tag); err != nil {
+			return err
+		}
+		for i, element := range elements {
+			path := fmt.Sprintf("%s[%d]", tag.path, i)
+			if err := reflectutils.Set(v, path, element); err != nil {
+				return fmt.Errorf("failed to set %q to %v: %w", path, v, err)
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,142 @@
+package stateful
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunfmin/reflectutils"
+)
+
+func TestRegisterQueryTagMethod(t *testing.T) {
+	upper := &QueryTagMethod{
+		Name:    "test_upper",
+		Encoder: `({ value, queries, tag }) => { queries.push(tag.name + '=' + value.toLowerCase()) }`,
+		Decoder: func(qs url.Values, tag QueryTag, v any) error {
+			qv, ok, err := lastQueryValue(qs, tag)
+			if err != nil || !ok {
+				return err
+			}
+			return reflectutils.Set(v, tag.Path(), fmt.Sprintf("%s!", qv))
+		},
+	}
+	RegisterQueryTagMethod(upper)
+
+	method, ok := LookupQueryTagMethod("test_upper")
+	require.True(t, ok)
+	assert.Same(t, upper, method)
+	assert.Contains(t, RegisteredQueryTagMethods(), upper)
+	assert.Contains(t, QueryTagMethods, upper)
+
+	assert.PanicsWithValue(t, `query tag method "test_upper" already registered`, func() {
+		RegisterQueryTagMethod(upper)
+	})
+	assert.PanicsWithValue(t, `query tag method "test_invalid" requires both encoder and decoder`, func() {
+		RegisterQueryTagMethod(&QueryTagMethod{Name: "test_invalid"})
+	})
+
+	type Compo struct {
+		Name string `query:"name;method:test_upper"`
+	}
+	var c Compo
+	tags, err := ParseQueryTags(c)
+	require.NoError(t, err)
+	require.NoError(t, tags.Decode("name=abc", &c))
+	assert.Equal(t, "abc!", c.Name)
+}
+
+func TestDeprecatedQueryTagMethods(t *testing.T) {
+	legacy := &QueryTagMethod{
+		Name:    "test_legacy",
+		Encoder: QueryTagMethodBare.Encoder,
+		Decoder: func(qs url.Values, tag QueryTag, v any) error {
+			return reflectutils.Set(v, tag.Path(), "legacy")
+		},
+	}
+	QueryTagMethods = append(QueryTagMethods, legacy)
+	t.Cleanup(func() {
+		QueryTagMethods = QueryTagMethods[:len(QueryTagMethods)-1]
+	})
+
+	method, ok := LookupQueryTagMethod("test_legacy")
+	require.True(t, ok)
+	assert.Same(t, legacy, method)
+	assert.Contains(t, RegisteredQueryTagMethods(), legacy)
+
+	type Compo struct {
+		Name string `query:"name;method:test_legacy"`
+	}
+	var c Compo
+	tags, err := ParseQueryTags(c)
+	require.NoError(t, err)
+	require.NoError(t, tags.Decode("name=abc", &c))
+	assert.Equal(t, "legacy", c.Name)
+
+	assert.PanicsWithValue(t, `query tag method "test_legacy" already registered`, func() {
+		RegisterQueryTagMethod(legacy)
+	})
+}
+
+func TestBuiltinQueryTagMethods(t *testing.T) {
+	type Filter struct {
+		Status string   `json:"status"`
+		Tags   []string `json:"tags"`
+	}
+
+	type Compo struct {
+		Filter    Filter              `query:"filter;method:json"`
+		Encoded   *Filter             `query:"encoded;method:base64json"`
+		Since     time.Time           `query:"since;method:time"`
+		SinceStr  string              `query:"since_str;method:time"`
+		Day       time.Time           `query:"day;method:date,Asia/Tokyo"`
+		Price     QueryRange[float64] `query:"price;method:range"`
+		Ages      []QueryRange[int]   `query:"ages;method:range"`
+		OpenEnded *QueryRange[int]    `query:"open;method:range"`
+		Status    string              `query:"status;method:enum,active,archived"`
+		Statuses  []string            `query:"statuses;method:enum,active,archived"`
+	}
+
+	var c Compo
+	tags, err := ParseQueryTags(c)
+	require.NoError(t, err)
+
+	q := url.Values{}
+	q.Set("filter", `{"status":"done","tags":["a","b"]}`)
+	q.Set("encoded", "eyJzdGF0dXMiOiLjgYLjgYQifQ") // {"status":"あい"}
+	q.Set("since", "2024-05-01T10:00:00+08:00")
+	q.Set("since_str", "2024-05-01T10:00:00Z")
+	q.Set("day", "2024-05-01")
+	// commas are kept as separators by the JS encoders
+	rawQuery := q.Encode() + "&price=1.5..20&ages=..18,60..&open=10..&status=archived&statuses=active,archived"
+
+	require.NoError(t, tags.Decode(rawQuery, &c))
+	assert.Equal(t, Filter{Status: "done", Tags: []string{"a", "b"}}, c.Filter)
+	assert.Equal(t, &Filter{Status: "あい"}, c.Encoded)
+	assert.True(t, c.Since.Equal(time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "2024-05-01T10:00:00Z", c.SinceStr)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, tokyo), c.Day)
+	assert.Equal(t, QueryRange[float64]{Min: ptrOf(1.5), Max: ptrOf(20.0)}, c.Price)
+	assert.Equal(t, []QueryRange[int]{{Max: ptrOf(18)}, {Min: ptrOf(60)}}, c.Ages)
+	assert.Equal(t, &QueryRange[int]{Min: ptrOf(10)}, c.OpenEnded)
+	assert.Equal(t, "archived", c.Status)
+	assert.Equal(t, []string{"active", "archived"}, c.Statuses)
+
+	err = tags.Decode("status=deleted", &c)
+	assert.ErrorContains(t, err, `invalid value "deleted", expected one of [active archived]`)
+
+	err = tags.Decode("price=10", &c)
+	assert.ErrorContains(t, err, `invalid range "10", expected min..max`)
+
+	require.NoError(t, tags.Decode("filter=&price=", &c))
+	assert.Equal(t, Filter{}, c.Filter)
+	assert.Equal(t, QueryRange[float64]{}, c.Price)
+}
+
+func ptrOf[T any](v T) *T {
+	return &v
+}
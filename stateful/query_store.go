@@ -0,0 +1,328 @@
+package stateful
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qor5/web/v3"
+)
+
+// QueryStore persists the sticky query fields tagged with `store:<name>`, e.g. `query:"page_size;store:cookie"`,
+// so that they are restored when the url does not have them.
+// The raw query of the store tags is saved whenever a synced action is posted, it is keyed by IdentifiableCookieKey.
+type QueryStore interface {
+	// Load returns the raw query saved for the key, or "" if nothing is saved.
+	Load(evCtx *web.EventContext, key string) (string, error)
+	Save(evCtx *web.EventContext, key string, rawQuery string) error
+}
+
+// ClientQueryStore is implemented by the stores persisted in the browser.
+// The server does not load or save them, instead the stored fields missing in the url are restored to the url on mount.
+type ClientQueryStore interface {
+	QueryStore
+	// ClientScript returns a js object with the functions load(key) and save(key, query),
+	// it is evaluated in a v-on-mounted callback, where window is its parameter since the templates can not access the globals.
+	ClientScript() string
+}
+
+const (
+	QueryStoreCookie = "cookie"
+	QueryStoreLocal  = "local"
+)
+
+var (
+	queryStoresMu sync.RWMutex
+	queryStores   = map[string]QueryStore{}
+)
+
+// RegisterQueryStore registers the store with the name used by the `store:<name>` of query tags.
+// It replaces the existing one, which allows to configure the built-in stores, e.g. to sign the cookies.
+func RegisterQueryStore(name string, store QueryStore) {
+	if strings.TrimSpace(name) == "" {
+		panic("query store name is required")
+	}
+	if store == nil {
+		panic(fmt.Sprintf("query store %q is nil", name))
+	}
+	queryStoresMu.Lock()
+	defer queryStoresMu.Unlock()
+	queryStores[name] = store
+}
+
+func LookupQueryStore(name string) (QueryStore, bool) {
+	queryStoresMu.RLock()
+	defer queryStoresMu.RUnlock()
+	v, ok := queryStores[name]
+	return v, ok
+}
+
+func init() {
+	RegisterQueryStore(QueryStoreCookie, &CookieQueryStore{})
+	RegisterQueryStore(QueryStoreLocal, LocalQueryStore{})
+}
+
+const (
+	DefaultQueryCookieMaxAge  = 30 * 24 * time.Hour
+	DefaultQueryCookieMaxSize = 4096
+)
+
+// CookieQueryStore saves the query to a cookie scoped to the page path with the response of the action.
+// The zero value is ready to use, the cookie is signed if Secret is set.
+type CookieQueryStore struct {
+	Secret   []byte
+	Path     string        // defaults to the path of the request
+	MaxAge   time.Duration // defaults to DefaultQueryCookieMaxAge
+	MaxSize  int           // defaults to DefaultQueryCookieMaxSize, the larger cookies are removed instead of saved
+	SameSite http.SameSite // defaults to http.SameSiteLaxMode
+	Secure   bool
+}
+
+func (s *CookieQueryStore) sign(name, value string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(name + "|" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *CookieQueryStore) Load(evCtx *web.EventContext, key string) (string, error) {
+	cookie, err := evCtx.R.Cookie(key)
+	if err == http.ErrNoCookie {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// the cookies written by the browser in the previous versions are the raw queries,
+	// they are migrated to the encoded ones by the next synced action, the base64 ones never have "=".
+	// They are unsigned, so they are trusted only if the cookies are not signed.
+	if isLegacyQueryCookie(cookie) {
+		if len(s.Secret) > 0 {
+			log.Printf("ignored the legacy unsigned query cookie %q\n", key)
+			return "", nil
+		}
+		log.Printf("loaded the legacy unsigned query cookie %q, it is replaced by the next synced action\n", key)
+		return cookie.Value, nil
+	}
+	value, signature, _ := strings.Cut(cookie.Value, ".")
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		log.Printf("ignored the invalid query cookie %q: %v\n", key, err)
+		return "", nil
+	}
+	rawQuery := string(b)
+	if len(s.Secret) > 0 && !hmac.Equal([]byte(signature), []byte(s.sign(key, rawQuery))) {
+		log.Printf("ignored the query cookie %q with the invalid signature\n", key)
+		return "", nil
+	}
+	return rawQuery, nil
+}
+
+func (s *CookieQueryStore) Save(evCtx *web.EventContext, key string, rawQuery string) error {
+	cookie := &http.Cookie{
+		Name:     key,
+		Path:     s.Path,
+		MaxAge:   int(s.MaxAge / time.Second),
+		SameSite: s.SameSite,
+		Secure:   s.Secure,
+		HttpOnly: true,
+	}
+	if cookie.Path == "" {
+		cookie.Path = evCtx.R.URL.Path
+	}
+	if cookie.MaxAge <= 0 {
+		cookie.MaxAge = int(DefaultQueryCookieMaxAge / time.Second)
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	maxSize := s.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultQueryCookieMaxSize
+	}
+
+	cookie.Value = base64.RawURLEncoding.EncodeToString([]byte(rawQuery))
+	if len(s.Secret) > 0 {
+		cookie.Value += "." + s.sign(key, rawQuery)
+	}
+	if rawQuery == "" || len(cookie.String()) > maxSize {
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(evCtx.W, cookie)
+
+	// the legacy cookie was written by the browser without a path, so it is scoped to the default path of the page,
+	// it is expired the same way, otherwise it shadows the one saved above
+	if legacy, err := evCtx.R.Cookie(key); err == nil && isLegacyQueryCookie(legacy) {
+		http.SetCookie(evCtx.W, &http.Cookie{Name: key, MaxAge: -1})
+	}
+	return nil
+}
+
+func isLegacyQueryCookie(cookie *http.Cookie) bool {
+	return strings.Contains(cookie.Value, "=")
+}
+
+// LocalQueryStore saves the query to the localStorage of the browser.
+type LocalQueryStore struct{}
+
+func (LocalQueryStore) Load(_ *web.EventContext, _ string) (string, error) {
+	return "", nil
+}
+
+func (LocalQueryStore) Save(_ *web.EventContext, _, _ string) error {
+	return nil
+}
+
+func (LocalQueryStore) ClientScript() string {
+	return `{
+	load(key) {
+		return window.localStorage.getItem(key);
+	},
+	save(key, query) {
+		if (query) {
+			window.localStorage.setItem(key, query);
+		} else {
+			window.localStorage.removeItem(key);
+		}
+	},
+}`
+}
+
+// QueryStorage is the key value storage of ServerQueryStore, e.g. backed by redis or a database.
+type QueryStorage interface {
+	// Get returns "" if the key does not exist.
+	Get(ctx context.Context, key string) (string, error)
+	// Set deletes the key if the value is "".
+	Set(ctx context.Context, key string, value string) error
+}
+
+// MemoryQueryStorage keeps the queries in memory, it is suitable for tests and single instance deployments.
+type MemoryQueryStorage struct {
+	m sync.Map
+}
+
+func (s *MemoryQueryStorage) Get(_ context.Context, key string) (string, error) {
+	v, ok := s.m.Load(key)
+	if !ok {
+		return "", nil
+	}
+	return v.(string), nil
+}
+
+func (s *MemoryQueryStorage) Set(_ context.Context, key string, value string) error {
+	if value == "" {
+		s.m.Delete(key)
+		return nil
+	}
+	s.m.Store(key, value)
+	return nil
+}
+
+const DefaultServerQueryMaxSize = 8192
+
+// ServerQueryStore saves the query on the server per user, it has to be registered with a name, e.g.
+//
+//	stateful.RegisterQueryStore("server", &stateful.ServerQueryStore{Storage: storage, UserKey: userKey})
+type ServerQueryStore struct {
+	Storage QueryStorage
+	// UserKey returns the key of the user of the request, nothing is loaded or saved if it is "".
+	UserKey func(r *http.Request) (string, error)
+	MaxSize int // defaults to DefaultServerQueryMaxSize, the larger queries are removed instead of saved
+}
+
+func (s *ServerQueryStore) storageKey(evCtx *web.EventContext, key string) (string, error) {
+	userKey, err := s.UserKey(evCtx.R)
+	if err != nil || userKey == "" {
+		return "", err
+	}
+	return userKey + ":" + key, nil
+}
+
+func (s *ServerQueryStore) Load(evCtx *web.EventContext, key string) (string, error) {
+	storageKey, err := s.storageKey(evCtx, key)
+	if err != nil || storageKey == "" {
+		return "", err
+	}
+	return s.Storage.Get(evCtx.R.Context(), storageKey)
+}
+
+func (s *ServerQueryStore) Save(evCtx *web.EventContext, key string, rawQuery string) error {
+	storageKey, err := s.storageKey(evCtx, key)
+	if err != nil || storageKey == "" {
+		return err
+	}
+	maxSize := s.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultServerQueryMaxSize
+	}
+	if len(rawQuery) > maxSize {
+		rawQuery = ""
+	}
+	return s.Storage.Set(evCtx.R.Context(), storageKey, rawQuery)
+}
+
+// saveStoredQueries saves the raw queries posted with the action to the server side stores
+func saveStoredQueries(evCtx *web.EventContext, c any, queries map[string]string) error {
+	ident, ok := c.(Identifiable)
+	if !ok {
+		return nil
+	}
+	tags, err := ParseQueryTags(c)
+	if err != nil {
+		return err
+	}
+	names := tags.StoreNames()
+	for name, rawQuery := range queries {
+		// posted by the client, e.g. the page rendered before the tags of the compo are changed
+		if !slices.Contains(names, name) {
+			log.Printf("ignored the query of store %q not used by compo %T\n", name, c)
+			continue
+		}
+		store, ok := LookupQueryStore(name)
+		if !ok {
+			log.Printf("ignored the query of store %q not registered\n", name)
+			continue
+		}
+		if _, ok := store.(ClientQueryStore); ok {
+			continue
+		}
+		if err := store.Save(evCtx, IdentifiableCookieKey(ident), rawQuery); err != nil {
+			return fmt.Errorf("failed to save query to store %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// restoreClientQuery decodes the raw query restored from the client stores into the compo, the invalid values are reset
+func restoreClientQuery(c any, rawQuery string) error {
+	if rawQuery == "" {
+		return nil
+	}
+	tags, err := ParseQueryTags(c)
+	if err != nil {
+		return err
+	}
+	var verrs web.ValidationErrors
+	for _, name := range tags.StoreNames() {
+		store, ok := LookupQueryStore(name)
+		if !ok {
+			continue
+		}
+		if _, ok := store.(ClientQueryStore); !ok {
+			continue
+		}
+		err := tags.StoreTags(name).Decode(rawQuery, c)
+		if err := mergeValidationErrors(&verrs, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
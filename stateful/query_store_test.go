@@ -0,0 +1,223 @@
+package stateful
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type storeTestCompo struct {
+	ID       string   `json:"id"`
+	PageSize int      `json:"page_size" query:"page_size;store:cookie"`
+	Keyword  string   `json:"keyword" query:"keyword;store:test_server"`
+	Columns  []string `json:"columns" query:"columns;store:local"`
+}
+
+func (c *storeTestCompo) CompoID() string {
+	return fmt.Sprintf("storeTestCompo:%s", c.ID)
+}
+
+func (c *storeTestCompo) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Text(fmt.Sprintf("[%d:%s:%v]", c.PageSize, c.Keyword, c.Columns))).MarshalHTML(ctx)
+}
+
+func init() {
+	RegisterActionableCompoType((*storeTestCompo)(nil))
+}
+
+func TestQueryStores(t *testing.T) {
+	storage := &MemoryQueryStorage{}
+	RegisterQueryStore("test_server", &ServerQueryStore{
+		Storage: storage,
+		UserKey: func(r *http.Request) (string, error) {
+			return r.Header.Get("X-User"), nil
+		},
+	})
+
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = SyncQuery(&storeTestCompo{ID: "0"})
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/list").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType: "*stateful.storeTestCompo",
+			Compo:     []byte(`{"id":"0","page_size":50,"keyword":"go","columns":["name"]}`),
+			SyncQuery: true,
+			Method:    actionMethodReload,
+			Request:   []byte(`{}`),
+			StoredQueries: map[string]string{
+				QueryStoreCookie: "page_size=50",
+				"test_server":    "keyword=go",
+				QueryStoreLocal:  "columns=name",
+			},
+		})).
+		BuildEventFuncRequest()
+	req.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	key := IdentifiableCookieKey(&storeTestCompo{ID: "0"})
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, key, cookies[0].Name)
+	assert.Equal(t, "/list", cookies[0].Path)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	assert.Positive(t, cookies[0].MaxAge)
+
+	stored, err := storage.Get(context.Background(), "alice:"+key)
+	require.NoError(t, err)
+	assert.Equal(t, "keyword=go", stored)
+
+	req = httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.Header.Set("X-User", "alice")
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "[50:go:[]]")
+	assert.Contains(t, w.Body.String(), "window.localStorage.getItem(key)")
+
+	// the url takes precedence over the stores
+	req = httptest.NewRequest(http.MethodGet, "/list?page_size=10", nil)
+	req.Header.Set("X-User", "bob")
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "[10::[]]")
+
+	req = multipartestutils.NewMultipartBuilder().
+		PageURL("/list").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType:     "*stateful.storeTestCompo",
+			Compo:         []byte(`{"id":"0"}`),
+			SyncQuery:     true,
+			Method:        actionMethodReload,
+			Request:       []byte(`{}`),
+			StoredQueries: map[string]string{"unknown": "a=b"},
+		})).
+		BuildEventFuncRequest()
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "the unknown stores posted by the client are ignored")
+	assert.Empty(t, w.Result().Cookies())
+
+	// the fields restored from the client stores on mount are reloaded in place
+	req = httptest.NewRequest(http.MethodGet, "/list", nil)
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "window.history.replaceState(")
+	assert.Contains(t, w.Body.String(), "v.restored_query = restored;")
+	assert.NotContains(t, w.Body.String(), "window.location.replace(")
+	assert.Contains(t, w.Body.String(), "setCookies: function(v) {\n\t\treturn this.storeQueries(v);")
+
+	req = multipartestutils.NewMultipartBuilder().
+		PageURL("/list").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType:     "*stateful.storeTestCompo",
+			Compo:         []byte(`{"id":"0"}`),
+			SyncQuery:     true,
+			Method:        actionMethodReload,
+			Request:       []byte(`{}`),
+			RestoredQuery: "columns=email&page_size=70",
+		})).
+		BuildEventFuncRequest()
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "[0::[email]]", "only the fields of the client stores are restored")
+}
+
+func TestCookieQueryStoreLegacyCookies(t *testing.T) {
+	RegisterQueryStore("test_server", &ServerQueryStore{
+		Storage: &MemoryQueryStorage{},
+		UserKey: func(r *http.Request) (string, error) { return "", nil },
+	})
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = SyncQuery(&storeTestCompo{ID: "0"})
+		return
+	})
+
+	// the raw query written by the browser in the previous versions
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.AddCookie(&http.Cookie{Name: IdentifiableCookieKey(&storeTestCompo{ID: "0"}), Value: "page_size=30"})
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "[30::[]]")
+}
+
+func TestCookieQueryStore(t *testing.T) {
+	store := &CookieQueryStore{Secret: []byte("secret"), Path: "/", MaxSize: 200}
+
+	newEventContext := func(cookies ...*http.Cookie) *web.EventContext {
+		r := httptest.NewRequest(http.MethodGet, "/list", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return &web.EventContext{R: r, W: httptest.NewRecorder()}
+	}
+
+	evCtx := newEventContext()
+	require.NoError(t, store.Save(evCtx, "k", "page_size=50&tags=a,b"))
+	cookies := evCtx.W.(*httptest.ResponseRecorder).Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "/", cookies[0].Path)
+
+	rawQuery, err := store.Load(newEventContext(cookies[0]), "k")
+	require.NoError(t, err)
+	assert.Equal(t, "page_size=50&tags=a,b", rawQuery)
+
+	// signed with the cookie name, so it can not be moved to another compo
+	moved := *cookies[0]
+	moved.Name = "other"
+	rawQuery, err = store.Load(newEventContext(&moved), "other")
+	require.NoError(t, err)
+	assert.Empty(t, rawQuery)
+
+	tampered := *cookies[0]
+	tampered.Value = base64.RawURLEncoding.EncodeToString([]byte("page_size=10")) + tampered.Value[strings.Index(tampered.Value, "."):]
+	rawQuery, err = store.Load(newEventContext(&tampered), "k")
+	require.NoError(t, err)
+	assert.Empty(t, rawQuery)
+
+	// the legacy unsigned cookie bypasses the signature, it is ignored and expired by the next save
+	legacy := &http.Cookie{Name: "k", Value: "page_size=10"}
+	rawQuery, err = store.Load(newEventContext(legacy), "k")
+	require.NoError(t, err)
+	assert.Empty(t, rawQuery)
+
+	rawQuery, err = (&CookieQueryStore{}).Load(newEventContext(legacy), "k")
+	require.NoError(t, err)
+	assert.Equal(t, "page_size=10", rawQuery)
+
+	evCtx = newEventContext(legacy)
+	require.NoError(t, store.Save(evCtx, "k", "page_size=50"))
+	cookies = evCtx.W.(*httptest.ResponseRecorder).Result().Cookies()
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "/", cookies[0].Path)
+	assert.Positive(t, cookies[0].MaxAge)
+	assert.Empty(t, cookies[1].Path)
+	assert.Equal(t, -1, cookies[1].MaxAge)
+
+	// too large to save, the existing one is removed
+	evCtx = newEventContext()
+	require.NoError(t, store.Save(evCtx, "k", "keyword="+string(make([]byte, 300))))
+	cookies = evCtx.W.(*httptest.ResponseRecorder).Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
@@ -2,9 +2,13 @@ package stateful
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/qor5/web/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	h "github.com/theplant/htmlgo"
 )
 
@@ -418,3 +422,160 @@ func TestDecodeToStruct(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryTagsDecodeBracket(t *testing.T) {
+	type Embedded struct {
+		Description string `json:"description"`
+		Country     string `json:"country"`
+	}
+
+	type Address struct {
+		*Embedded
+		Country  string   `json:"country"` // takes precedence over the embedded one
+		City     string   `json:"city"`
+		Distance *int     `json:"distance"`
+		Tags     []string `json:"tags"`
+		internal string
+	}
+
+	type Filter struct {
+		Status  string            `json:"status"`
+		Labels  map[string]string `json:"labels"`
+		Since   time.Time         `json:"since"`
+		Address *Address          `json:"address"`
+	}
+
+	type LegacyAddress struct {
+		City    string `json:"city"`
+		Country string `json:"country"`
+	}
+
+	type User struct {
+		Filter    Filter          `query:"filter"`
+		Addresses []Address       `query:"addresses"`
+		Counts    map[string]int  `query:"counts"`
+		Extra     map[string]any  `query:"extra"`
+		Legacy    []LegacyAddress `query:"legacy"`
+		Untouched Filter          `query:"untouched"`
+	}
+
+	user := User{
+		Filter:    Filter{Status: "overwritten"},
+		Untouched: Filter{Status: "kept"},
+	}
+	tags, err := ParseQueryTags(user)
+	require.NoError(t, err)
+
+	q := strings.Join([]string{
+		"filter[status]=active",
+		"filter[labels][env]=prod",
+		"filter[labels][team%20name]=a%26b",
+		"filter[since]=2024-05-01T00:00:00Z",
+		"filter[address][city]=Shanghai",
+		"filter[address][tags]=a%2C,b",
+		"filter[address][unknown]=ignored",
+		"addresses[1][city]=Hangzhou",
+		"addresses[1][distance]=12",
+		"addresses[0][city]=Suzhou",
+		"addresses[0][country]=China",
+		"addresses[0][description]=descA",
+		"addresses[0][distance]=",
+		"counts[a]=1",
+		"counts[b]=2",
+		"extra[x][y]=z",
+		"legacy=Shanghai_China",
+	}, "&")
+
+	err = tags.Decode(q, &user)
+	require.NoError(t, err)
+	assert.Equal(t, Filter{
+		Status: "active",
+		Labels: map[string]string{"env": "prod", "team name": "a&b"},
+		Since:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Address: &Address{
+			City: "Shanghai",
+			Tags: []string{"a,", "b"},
+		},
+	}, user.Filter)
+	distance := 12
+	assert.Equal(t, []Address{
+		{
+			Embedded: &Embedded{Description: "descA"},
+			City:     "Suzhou",
+			Country:  "China",
+		},
+		{
+			City:     "Hangzhou",
+			Distance: &distance,
+		},
+	}, user.Addresses)
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, user.Counts)
+	assert.Equal(t, map[string]any{"x": map[string]any{"y": "z"}}, user.Extra)
+	assert.Equal(t, []LegacyAddress{{City: "Shanghai", Country: "China"}}, user.Legacy)
+	assert.Equal(t, "kept", user.Untouched.Status)
+
+	err = tags.Decode("counts[a]=x", &user)
+	assert.ErrorContains(t, err, `failed to decode "counts": a: strconv.ParseInt: parsing "x": invalid syntax`)
+
+	err = tags.Decode("filter[status]=a&filter[status][x]=b", &user)
+	assert.ErrorContains(t, err, `conflicting query key "filter[status][x]"`)
+}
+
+func TestQueryTagsDefaultsAndValidation(t *testing.T) {
+	type Compo struct {
+		Page     int      `query:"page" default:"1" min:"1"`
+		PageSize int      `query:"page_size" default:"20" min:"1" max:"100"`
+		Ratio    *float64 `query:"ratio" min:"0" max:"1"`
+		OrderBy  string   `query:"order_by" default:"created_at" oneof:"name created_at"`
+		Columns  []string `query:"columns" default:"name,age" oneof:"name age email"`
+		Keyword  string   `query:"keyword"`
+	}
+
+	var c Compo
+	tags, err := ParseQueryTags(c)
+	require.NoError(t, err)
+
+	require.NoError(t, tags.SetDefaults(&c))
+	assert.Equal(t, Compo{Page: 1, PageSize: 20, OrderBy: "created_at", Columns: []string{"name", "age"}}, c)
+
+	// the fields set by the caller are kept
+	preset := Compo{PageSize: 50, OrderBy: "name"}
+	require.NoError(t, tags.SetDefaults(&preset))
+	assert.Equal(t, Compo{Page: 1, PageSize: 50, OrderBy: "name", Columns: []string{"name", "age"}}, preset)
+
+	require.NoError(t, tags.Decode("page=3&page_size=50&order_by=name&columns=email", &c))
+	assert.Equal(t, Compo{Page: 3, PageSize: 50, OrderBy: "name", Columns: []string{"email"}}, c)
+
+	err = tags.Decode("page=-5&page_size=500&ratio=1.5&order_by=unknown&columns=name,password,age&keyword=x", &c)
+	var verrs *web.ValidationErrors
+	require.ErrorAs(t, err, &verrs)
+	assert.Equal(t, map[string][]string{
+		"page":      {"-5 is less than the minimum 1"},
+		"page_size": {"500 is greater than the maximum 100"},
+		"ratio":     {"1.5 is greater than the maximum 1"},
+		"order_by":  {`invalid value "unknown", expected one of [name created_at]`},
+		"columns":   {`invalid value "password", expected one of [name age email]`},
+	}, verrs.FieldErrors())
+	assert.Equal(t, Compo{Page: 1, PageSize: 100, Ratio: ptrOf(1.0), OrderBy: "created_at", Columns: []string{"name", "age"}, Keyword: "x"}, c)
+
+	for _, v := range []any{
+		struct {
+			Name string `query:"name" min:"1"`
+		}{},
+		struct {
+			Page int `query:"page" min:"10" max:"1"`
+		}{},
+		struct {
+			Page int `query:"page" default:"0" min:"1"`
+		}{},
+		struct {
+			Page int `query:"page" default:"x"`
+		}{},
+		struct {
+			Status string `query:"status" oneof:" "`
+		}{},
+	} {
+		_, err := ParseQueryTags(v)
+		assert.Error(t, err, "%+v", v)
+	}
+}
@@ -0,0 +1,242 @@
+package stateful
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/qor5/web/v3"
+)
+
+// The struct tags below set the default value and validate the synced query fields, e.g.
+//
+//	PageSize int    `query:"page_size" default:"20" min:"1" max:"100"`
+//	OrderBy  string `query:"order_by" default:"created_at" oneof:"name created_at"`
+//
+// Numbers out of min/max are clamped and values not in oneof are reset to the default,
+// both are reported by the ValidationErrors returned from QueryTags.Decode.
+const (
+	tagDefault = "default"
+	tagMin     = "min"
+	tagMax     = "max"
+	tagOneOf   = "oneof"
+)
+
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// queryElemType returns the element type for slices and arrays, otherwise the type itself
+func queryElemType(rt reflect.Type) reflect.Type {
+	rt = unwrapPtrType(rt)
+	if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
+		return unwrapPtrType(rt.Elem())
+	}
+	return rt
+}
+
+func parseQueryValidationTags(structField reflect.StructField, tag *QueryTag) error {
+	if v, ok := structField.Tag.Lookup(tagDefault); ok {
+		tag.Default = &v
+	}
+	for _, bound := range []struct {
+		key  string
+		dest **float64
+	}{{tagMin, &tag.Min}, {tagMax, &tag.Max}} {
+		v, ok := structField.Tag.Lookup(bound.key)
+		if !ok {
+			continue
+		}
+		if !isNumberKind(queryElemType(structField.Type).Kind()) {
+			return fmt.Errorf("%s tag requires a number field", bound.key)
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%s tag is invalid: %w", bound.key, err)
+		}
+		*bound.dest = &f
+	}
+	if tag.Min != nil && tag.Max != nil && *tag.Min > *tag.Max {
+		return errors.New("min tag is greater than max tag")
+	}
+	if v, ok := structField.Tag.Lookup(tagOneOf); ok {
+		tag.OneOf = strings.Fields(v)
+		if len(tag.OneOf) == 0 {
+			return errors.New("oneof tag is empty")
+		}
+	}
+
+	// the custom methods may not be registered yet, so only the default values of the plain fields are checked here
+	if tag.Default != nil && tag.Method == "" {
+		rv := reflect.New(structField.Type).Elem()
+		if err := decodeQueryNode(rv, &queryNode{value: tag.Default}); err != nil {
+			return fmt.Errorf("default tag %q is invalid: %w", *tag.Default, err)
+		}
+		if msgs, rejected := tag.validateValue(rv); len(msgs) > 0 || rejected {
+			return fmt.Errorf("default tag %q is invalid: %s", *tag.Default, strings.Join(msgs, "; "))
+		}
+	}
+	return nil
+}
+
+func destField(dest any, path string) (reflect.Value, error) {
+	rv := reflect.ValueOf(dest)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	return fieldByNameAlloc(rv, path)
+}
+
+// SetDefaults sets the zero fields with the default tag to their default values, the fields set by the caller are kept.
+// SyncQuery calls it before decoding, so the fields absent in the url and set by nobody get their defaults.
+func (tags QueryTags) SetDefaults(dest any) error {
+	for _, tag := range tags {
+		if tag.Default == nil {
+			continue
+		}
+		field, err := destField(dest, tag.path)
+		if err != nil {
+			return err
+		}
+		if !field.IsZero() {
+			continue
+		}
+		if err := tag.reset(dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset sets the field to the default value, or zero value if no default
+func (tag QueryTag) reset(dest any) error {
+	field, err := destField(dest, tag.path)
+	if err != nil {
+		return err
+	}
+	field.Set(reflect.Zero(field.Type()))
+	if tag.Default == nil {
+		return nil
+	}
+	if err := decodeQueryTag(url.Values{tag.Name: {*tag.Default}}, tag, dest); err != nil {
+		return fmt.Errorf("failed to set default of %q: %w", tag.Name, err)
+	}
+	return nil
+}
+
+func (tag QueryTag) validate(dest any, verrs *web.ValidationErrors) error {
+	if tag.Min == nil && tag.Max == nil && len(tag.OneOf) == 0 {
+		return nil
+	}
+	field, err := destField(dest, tag.path)
+	if err != nil {
+		return err
+	}
+	msgs, rejected := tag.validateValue(field)
+	for _, msg := range msgs {
+		verrs.FieldError(tag.Name, msg)
+	}
+	if rejected {
+		return tag.reset(dest)
+	}
+	return nil
+}
+
+// validateValue clamps the numbers and drops the slice elements not in oneof,
+// rejected is true if the whole value should be reset.
+func (tag QueryTag) validateValue(rv reflect.Value) (msgs []string, rejected bool) {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice:
+		kept := reflect.MakeSlice(rv.Type(), 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			elemMsgs, ok := tag.validateScalar(rv.Index(i))
+			msgs = append(msgs, elemMsgs...)
+			if ok {
+				kept = reflect.Append(kept, rv.Index(i))
+			}
+		}
+		if kept.Len() != rv.Len() {
+			rv.Set(kept)
+		}
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			elemMsgs, ok := tag.validateScalar(rv.Index(i))
+			msgs = append(msgs, elemMsgs...)
+			if !ok {
+				rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+			}
+		}
+	default:
+		msgs, ok := tag.validateScalar(rv)
+		return msgs, !ok
+	}
+	return msgs, false
+}
+
+func (tag QueryTag) validateScalar(rv reflect.Value) (msgs []string, ok bool) {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, true
+		}
+		rv = rv.Elem()
+	}
+
+	if len(tag.OneOf) > 0 {
+		s := fmt.Sprint(rv.Interface())
+		if !slices.Contains(tag.OneOf, s) {
+			return []string{fmt.Sprintf("invalid value %q, expected one of %v", s, tag.OneOf)}, false
+		}
+	}
+
+	if !isNumberKind(rv.Kind()) {
+		return nil, true
+	}
+	var f float64
+	switch {
+	case rv.CanInt():
+		f = float64(rv.Int())
+	case rv.CanUint():
+		f = float64(rv.Uint())
+	default:
+		f = rv.Float()
+	}
+	if tag.Min != nil && f < *tag.Min {
+		msgs = append(msgs, fmt.Sprintf("%v is less than the minimum %v", rv.Interface(), *tag.Min))
+		setQueryNumber(rv, *tag.Min, math.Ceil)
+	}
+	if tag.Max != nil && f > *tag.Max {
+		msgs = append(msgs, fmt.Sprintf("%v is greater than the maximum %v", rv.Interface(), *tag.Max))
+		setQueryNumber(rv, *tag.Max, math.Floor)
+	}
+	return msgs, true
+}
+
+// setQueryNumber sets f to rv, round is used to convert f to integers
+func setQueryNumber(rv reflect.Value, f float64, round func(float64) float64) {
+	switch {
+	case rv.CanInt():
+		rv.SetInt(int64(round(f)))
+	case rv.CanUint():
+		rv.SetUint(uint64(math.Max(round(f), 0)))
+	default:
+		rv.SetFloat(f)
+	}
+}
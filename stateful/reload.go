@@ -2,6 +2,7 @@ package stateful
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/qor5/web/v3"
@@ -29,7 +30,8 @@ func SkipPortalize(c Identifiable) h.HTMLComponent {
 			portalName = c.CompoID()
 		}
 		ctx = context.WithValue(ctx, skipPortalNameCtxKey{}, portalName)
-		return c.MarshalHTML(ctx)
+		// the reload replaces the portals of the compo and its descendants at once
+		return c.MarshalHTML(withRenderPass(ctx))
 	})
 }
 
@@ -38,6 +40,7 @@ func (p *portalize) MarshalHTML(ctx context.Context) ([]byte, error) {
 	if portalName == "" {
 		portalName = p.c.CompoID()
 	}
+	trackCompoID(ctx, portalName, p.c)
 	skipName, _ := ctx.Value(skipPortalNameCtxKey{}).(string)
 	if skipName == portalName {
 		return h.Components(p.children...).MarshalHTML(ctx)
@@ -89,6 +92,67 @@ func AppendReloadToResponse(r *web.EventResponse, c Identifiable) {
 	})
 }
 
+type dispatchedCompoCtxKey struct{}
+
+type dispatchedCompo struct {
+	compo    h.HTMLComponent
+	restored json.RawMessage
+}
+
+// withDispatchedCompo records the compo of the action and the state it was restored from
+func withDispatchedCompo(ctx context.Context, c h.HTMLComponent, restored json.RawMessage) context.Context {
+	return context.WithValue(ctx, dispatchedCompoCtxKey{}, &dispatchedCompo{compo: c, restored: restored})
+}
+
+// AppendPatchToResponse updates the state of the dispatched compo in the browser with a json patch instead of re-rendering it,
+// only the parts of the template bound to the state like `v-text="locals.compo.count"` are updated.
+// The patch is applied to the locals of the compo registered by its id, wherever the action is triggered,
+// and the version of a Versioned compo is advanced to the current one, so the next action is not rejected as a conflict.
+// It falls back to AppendReloadToResponse if c is not the compo of the dispatched action.
+func AppendPatchToResponse(ctx context.Context, r *web.EventResponse, c Identifiable) {
+	dispatched, ok := ctx.Value(dispatchedCompoCtxKey{}).(*dispatchedCompo)
+	if !ok || dispatched.compo != c {
+		AppendReloadToResponse(r, c)
+		return
+	}
+
+	patch, err := jsondiff.CompareJSON(dispatched.restored, []byte(PrettyJSONString(c)))
+	if err != nil {
+		panic(err)
+	}
+	script := patchScriptPrefix
+	if patch != nil {
+		script += patchScriptApply + h.JSONString(patch) + ");"
+	}
+	if v, ok := c.(Versioned); ok {
+		current, err := v.CurrentVersion(ctx)
+		if err != nil {
+			// the reload renders the current version
+			AppendReloadToResponse(r, c)
+			return
+		}
+		script += patchScriptVersion + h.JSONString(current) + ";"
+	}
+	if script == patchScriptPrefix {
+		return
+	}
+	if r.RunScript != "" {
+		r.RunScript += ";\n"
+	}
+	r.RunScript += script + patchScriptSuffix(c.CompoID())
+}
+
+// the parts of the scripts appended by AppendPatchToResponse, they are parsed by applyPatchScript
+const (
+	patchScriptPrefix  = "((locals) => { if (locals) {"
+	patchScriptApply   = " plaid().applyJsonPatch(locals." + LocalsKeyCompo + ", "
+	patchScriptVersion = " locals." + LocalsKeyVersion + " = "
+)
+
+func patchScriptSuffix(compoID string) string {
+	return fmt.Sprintf(" } })((vars.%s || {})[%s])", varsKeyCompos, h.JSONString(compoID))
+}
+
 func OnReload(c Identifiable) (r web.EventResponse, err error) {
 	r.UpdatePortals = append(r.UpdatePortals, &web.PortalUpdate{
 		Name: c.CompoID(),
@@ -0,0 +1,78 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type patchTestCounter struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+func (c *patchTestCounter) CompoID() string {
+	return fmt.Sprintf("patchTestCounter:%s", c.ID)
+}
+
+func (c *patchTestCounter) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Span("").Attr("v-text", "locals.compo.count")).MarshalHTML(ctx)
+}
+
+func (c *patchTestCounter) Incr(ctx context.Context) (r web.EventResponse, err error) {
+	c.Count++
+	AppendPatchToResponse(ctx, &r, c)
+	// not the dispatched one, so it falls back to reload
+	AppendPatchToResponse(ctx, &r, &patchTestCounter{ID: "other"})
+	return
+}
+
+func init() {
+	RegisterActionableCompoType((*patchTestCounter)(nil))
+}
+
+func TestAppendPatchToResponse(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &patchTestCounter{ID: "0"}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, w.Body.String(), `"compo":{"id":"0","count":0}`)
+	assert.Contains(t, w.Body.String(), "v.compo = JSON.parse(JSON.stringify(this.compo));")
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType: "*stateful.patchTestCounter",
+			Compo:     []byte(`{"id":"0","count":1}`),
+			Method:    "Incr",
+			Request:   []byte(`{}`),
+		})).
+		BuildEventFuncRequest()
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+
+	var r struct {
+		RunScript     string `json:"runScript"`
+		UpdatePortals []struct {
+			Name string `json:"name"`
+		} `json:"updatePortals"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+	// patched by the compo id instead of the locals of the scope the action is triggered in
+	assert.Equal(t, `((locals) => { if (locals) { plaid().applyJsonPatch(locals.compo, [{"value":2,"op":"replace","path":"/count"}]); } })((vars.__statefulCompos || {})["patchTestCounter:0"])`, r.RunScript)
+	require.Len(t, r.UpdatePortals, 1)
+	assert.Equal(t, "patchTestCounter:other", r.UpdatePortals[0].Name)
+}
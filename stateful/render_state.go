@@ -0,0 +1,61 @@
+package stateful
+
+import (
+	"context"
+	"sync"
+
+	"github.com/qor5/web/v3"
+)
+
+type renderStateCtxKey struct{}
+
+// renderState is what the compos rendered in the page or the event response of a request
+type renderState struct {
+	pass *renderPass // the pass of the page or the bodies of the event response
+}
+
+// renderStateFromContext returns the state of the request, it returns nil if not rendered in a request
+func renderStateFromContext(ctx context.Context) *renderState {
+	evCtx, ok := web.GetEventContext(ctx)
+	if !ok {
+		return nil
+	}
+	state, ok := evCtx.ContextValue(renderStateCtxKey{}).(*renderState)
+	if !ok {
+		state = &renderState{
+			pass: newRenderPass(),
+		}
+		evCtx.WithContextValue(renderStateCtxKey{}, state)
+	}
+	return state
+}
+
+type renderPassCtxKey struct{}
+
+// renderPass is the compo ids rendered in a tree which replaces the portals in the browser at once,
+// it is the page, or a compo reloaded by AppendReloadToResponse which renders its descendants again.
+type renderPass struct {
+	mu       sync.Mutex
+	compoIDs map[string]string // the portal name to the compo type
+}
+
+func newRenderPass() *renderPass {
+	return &renderPass{compoIDs: map[string]string{}}
+}
+
+// withRenderPass starts a new pass for the tree rendered with the returned context
+func withRenderPass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, renderPassCtxKey{}, newRenderPass())
+}
+
+// renderPassFromContext returns the pass of the tree, it returns nil if not rendered in a request
+func renderPassFromContext(ctx context.Context) *renderPass {
+	if pass, ok := ctx.Value(renderPassCtxKey{}).(*renderPass); ok {
+		return pass
+	}
+	state := renderStateFromContext(ctx)
+	if state == nil {
+		return nil
+	}
+	return state.pass
+}
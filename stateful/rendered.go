@@ -0,0 +1,253 @@
+package stateful
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/qor5/web/v3/stateful/internal/harness"
+	"github.com/wI2L/jsondiff"
+	"golang.org/x/net/html"
+)
+
+func init() {
+	harness.ParseRenderedCompos = func(body string) ([]*harness.RenderedCompo, error) {
+		compos, err := parseRenderedCompos(body)
+		if err != nil {
+			return nil, err
+		}
+		rcs := make([]*harness.RenderedCompo, 0, len(compos))
+		for _, c := range compos {
+			action, err := json.Marshal(c.Action)
+			if err != nil {
+				return nil, err
+			}
+			rcs = append(rcs, &harness.RenderedCompo{PortalName: c.PortalName, Action: action})
+		}
+		return rcs, nil
+	}
+	harness.ApplyPatchScript = func(runScript string, compoID string, state json.RawMessage, version string) (json.RawMessage, string, error) {
+		action := &Action{CompoID: compoID, Compo: state, Version: version}
+		if _, err := applyPatchScript(runScript, action); err != nil {
+			return nil, "", err
+		}
+		return action.Compo, action.Version, nil
+	}
+	harness.EncodeQuery = func(c any, state json.RawMessage, defaults map[string]string, store string, current url.Values) (string, error) {
+		tags, err := ParseQueryTags(c)
+		if err != nil {
+			return "", err
+		}
+		if store != "" {
+			tags = tags.StoreTags(store)
+		}
+		return tags.encode(state, defaults, current)
+	}
+}
+
+// renderedCompo is a compo rendered by Actionable, it is parsed from the html by parseRenderedCompos,
+// so its actions can be posted without a browser, e.g. by the testutil package.
+type renderedCompo struct {
+	// PortalName is the name of the portal the Identifiable compo is rendered in, it is empty for the others
+	PortalName string
+	// Action is the action base the compo posts, with the state and the version it is rendered with
+	Action Action
+}
+
+// parseRenderedCompos returns the compos rendered by Actionable in the html in document order
+func parseRenderedCompos(body string) ([]*renderedCompo, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var compos []*renderedCompo
+	var walk func(n *html.Node, portalName string) error
+	walk = func(n *html.Node, portalName string) error {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "go-plaid-portal":
+				portalName = htmlAttr(n, "portal-name")
+			case "go-plaid-scope":
+				c, err := parseRenderedCompo(htmlAttr(n, ":init"))
+				if err != nil {
+					return err
+				}
+				if c != nil {
+					c.PortalName = portalName
+					compos = append(compos, c)
+				}
+				portalName = ""
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if err := walk(child, portalName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(doc, ""); err != nil {
+		return nil, err
+	}
+	return compos, nil
+}
+
+// parseRenderedCompo decodes the renderedLocals which are the first of the locals rendered by Actionable,
+// it returns nil if the locals are not rendered by Actionable
+func parseRenderedCompo(init string) (*renderedCompo, error) {
+	dec := json.NewDecoder(strings.NewReader(init))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, nil
+	}
+	var data renderedLocals
+	if err := dec.Decode(&data); err != nil || data.ActionBase.CompoType == "" {
+		return nil, nil
+	}
+	c := &renderedCompo{Action: data.ActionBase}
+	c.Action.Compo, c.Action.Version = data.Compo, data.Version
+	return c, nil
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// applyPatchScript applies the patches and the versions appended by AppendPatchToResponse for the compo of the action
+// to its Compo and Version in order, like the browser does with the run script of the response.
+// It reports whether the compo is patched by the run script.
+func applyPatchScript(runScript string, action *Action) (bool, error) {
+	suffix := patchScriptSuffix(action.CompoID)
+	patched := false
+	for rest := runScript; ; {
+		end := strings.Index(rest, suffix)
+		if end < 0 {
+			return patched, nil
+		}
+		start := strings.LastIndex(rest[:end], patchScriptPrefix)
+		if start < 0 {
+			return patched, fmt.Errorf("invalid patch script of compo %q", action.CompoID)
+		}
+		script := rest[start+len(patchScriptPrefix) : end]
+		rest = rest[end+len(suffix):]
+
+		if strings.HasPrefix(script, patchScriptApply) {
+			var patch jsondiff.Patch
+			dec := json.NewDecoder(strings.NewReader(script[len(patchScriptApply):]))
+			if err := dec.Decode(&patch); err != nil {
+				return patched, fmt.Errorf("failed to decode json patch: %w", err)
+			}
+			compo, err := applyJSONPatch(action.Compo, patch)
+			if err != nil {
+				return patched, err
+			}
+			action.Compo = compo
+			script = strings.TrimPrefix(script[len(patchScriptApply)+int(dec.InputOffset()):], ");")
+		}
+		if strings.HasPrefix(script, patchScriptVersion) {
+			if err := json.NewDecoder(strings.NewReader(script[len(patchScriptVersion):])).Decode(&action.Version); err != nil {
+				return patched, fmt.Errorf("failed to decode version: %w", err)
+			}
+		}
+		patched = true
+	}
+}
+
+// applyJSONPatch supports the operations generated by jsondiff without options
+func applyJSONPatch(state []byte, patch jsondiff.Patch) (json.RawMessage, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(state))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	for _, op := range patch {
+		var err error
+		if doc, err = applyJSONPatchOp(doc, op); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(doc)
+}
+
+func applyJSONPatchOp(doc any, op jsondiff.Operation) (any, error) {
+	if op.Path == "" {
+		switch op.Type {
+		case jsondiff.OperationAdd, jsondiff.OperationReplace:
+			return op.Value, nil
+		}
+		return nil, fmt.Errorf("json patch %q of the root is not supported", op.Type)
+	}
+	if !strings.HasPrefix(op.Path, "/") {
+		return nil, fmt.Errorf("invalid json patch path %q", op.Path)
+	}
+	tokens := strings.Split(op.Path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return patchJSONValue(doc, tokens, op)
+}
+
+func patchJSONValue(parent any, tokens []string, op jsondiff.Operation) (any, error) {
+	token := tokens[0]
+	last := len(tokens) == 1
+	switch p := parent.(type) {
+	case map[string]any:
+		if !last {
+			child, ok := p[token]
+			if !ok {
+				return nil, fmt.Errorf("json patch path %q not found", op.Path)
+			}
+			v, err := patchJSONValue(child, tokens[1:], op)
+			if err != nil {
+				return nil, err
+			}
+			p[token] = v
+			return p, nil
+		}
+		switch op.Type {
+		case jsondiff.OperationAdd, jsondiff.OperationReplace:
+			p[token] = op.Value
+		case jsondiff.OperationRemove:
+			delete(p, token)
+		default:
+			return nil, fmt.Errorf("json patch %q is not supported", op.Type)
+		}
+		return p, nil
+	case []any:
+		if last && op.Type == jsondiff.OperationAdd && token == "-" {
+			return append(p, op.Value), nil
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > len(p) || (i == len(p) && !(last && op.Type == jsondiff.OperationAdd)) {
+			return nil, fmt.Errorf("json patch path %q is out of range", op.Path)
+		}
+		if !last {
+			v, err := patchJSONValue(p[i], tokens[1:], op)
+			if err != nil {
+				return nil, err
+			}
+			p[i] = v
+			return p, nil
+		}
+		switch op.Type {
+		case jsondiff.OperationAdd:
+			return append(p[:i], append([]any{op.Value}, p[i:]...)...), nil
+		case jsondiff.OperationReplace:
+			p[i] = op.Value
+			return p, nil
+		case jsondiff.OperationRemove:
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("json patch %q is not supported", op.Type)
+	}
+	return nil, fmt.Errorf("json patch path %q does not point to a container", op.Path)
+}
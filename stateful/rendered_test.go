@@ -0,0 +1,97 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+func TestParseRenderedCompos(t *testing.T) {
+	versionTestRecords["rendered"] = &versionTestRecord{title: "draft", version: 3}
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = h.Components(
+			&eventTestList{ID: "0", Saved: []string{"x"}},
+			SyncQuery(&versionTestDoc{ID: "rendered"}),
+		)
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	compos, err := parseRenderedCompos(w.Body.String())
+	require.NoError(t, err)
+	require.Len(t, compos, 3)
+
+	assert.Equal(t, "eventTestList:0", compos[0].PortalName)
+	assert.Equal(t, "*stateful.eventTestList", compos[0].Action.CompoType)
+	assert.JSONEq(t, `{"id":"0","saved":["x"]}`, string(compos[0].Action.Compo))
+
+	assert.Equal(t, "eventTestRow:a", compos[1].PortalName)
+	assert.JSONEq(t, `{"id":"a"}`, string(compos[1].Action.Compo))
+	require.Len(t, compos[1].Action.Ancestors, 1)
+	assert.Equal(t, "eventTestList:0", compos[1].Action.Ancestors[0].CompoID)
+	assert.Empty(t, compos[1].Action.Ancestors[0].Compo, "the live state is added by the browser")
+
+	assert.Equal(t, "versionTestDoc:rendered", compos[2].Action.CompoID)
+	assert.True(t, compos[2].Action.SyncQuery)
+	assert.Equal(t, "3", compos[2].Action.Version)
+
+	// the scopes not rendered by Actionable are skipped
+	compos, err = parseRenderedCompos(h.MustString(web.Scope().Init(`[{a: 1}, {b: 2}]`), context.Background()))
+	require.NoError(t, err)
+	assert.Empty(t, compos)
+}
+
+func TestApplyPatchScript(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &patchTestCounter{ID: "0"}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL("/").
+		EventFunc(EventDispatchAction).
+		AddField(FieldKeyAction, PrettyJSONString(Action{
+			CompoType: "*stateful.patchTestCounter",
+			CompoID:   "patchTestCounter:0",
+			Compo:     []byte(`{"id":"0","count":1}`),
+			Method:    "Incr",
+			Request:   []byte(`{}`),
+		})).
+		BuildEventFuncRequest()
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, req)
+	var r multipartestutils.TestEventResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+
+	action := &Action{CompoID: "patchTestCounter:0", Compo: []byte(`{"id":"0","count":1}`)}
+	patched, err := applyPatchScript(r.RunScript, action)
+	require.NoError(t, err)
+	assert.True(t, patched)
+	assert.JSONEq(t, `{"id":"0","count":2}`, string(action.Compo))
+
+	// patched in order, with the versions
+	action = &Action{CompoID: "c", Compo: []byte(`{"items":["a","b"],"name":"x"}`)}
+	script := `alert(1);
+((locals) => { if (locals) { plaid().applyJsonPatch(locals.compo, [{"op":"add","path":"/items/1","value":"c"},{"op":"remove","path":"/name"}]); locals.version = "2"; } })((vars.__statefulCompos || {})["c"]);
+((locals) => { if (locals) { plaid().applyJsonPatch(locals.compo, [{"op":"replace","path":"/items/0","value":"<b>"}]); } })((vars.__statefulCompos || {})["other"]);
+((locals) => { if (locals) { locals.version = "3"; } })((vars.__statefulCompos || {})["c"])`
+	patched, err = applyPatchScript(script, action)
+	require.NoError(t, err)
+	assert.True(t, patched)
+	assert.JSONEq(t, `{"items":["a","c","b"]}`, string(action.Compo))
+	assert.Equal(t, "3", action.Version)
+
+	patched, err = applyPatchScript("alert(1)", action)
+	require.NoError(t, err)
+	assert.False(t, patched)
+}
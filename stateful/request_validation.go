@@ -0,0 +1,136 @@
+package stateful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/qor5/web/v3"
+	h "github.com/theplant/htmlgo"
+)
+
+// The requests of the action methods are validated after unmarshalled, the action method is not called if they are invalid, e.g.
+//
+//	type RenameRequest struct {
+//		Title string `json:"title" validate:"required,max=100"`
+//	}
+//
+// The `validate` tags are checked by RequestValidate, and the requests implementing RequestValidator are checked by themselves.
+
+// RequestValidator is implemented by the requests which validate themselves, e.g. for the rules across the fields.
+type RequestValidator interface {
+	Validate() *web.ValidationErrors
+}
+
+// RequestValidate checks the `validate` tags of the requests, the custom validations can be registered to it.
+// The field names of the errors are the json paths, e.g. items[0].name.
+var RequestValidate = newRequestValidate()
+
+func newRequestValidate() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get(tagJson), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
+
+// RequestValidationMessage returns the message of the failed `validate` tag.
+var RequestValidationMessage = func(fe validator.FieldError) string {
+	if fe.Param() != "" {
+		return fmt.Sprintf("failed on the %s=%s validation", fe.Tag(), fe.Param())
+	}
+	return fmt.Sprintf("failed on the %s validation", fe.Tag())
+}
+
+type requestValidationError struct {
+	verrs *web.ValidationErrors
+}
+
+func (e *requestValidationError) Error() string {
+	return e.verrs.Error()
+}
+
+// validateActionRequest returns a requestValidationError if req, which is a pointer, is invalid
+func validateActionRequest(req any) error {
+	rv := reflect.ValueOf(req)
+	for rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	req = rv.Interface()
+
+	var verrs web.ValidationErrors
+	if rv.Elem().Kind() == reflect.Struct {
+		err := RequestValidate.Struct(req)
+		var fes validator.ValidationErrors
+		if errors.As(err, &fes) {
+			for _, fe := range fes {
+				// the namespace starts with the name of the request type
+				_, field, _ := strings.Cut(fe.Namespace(), ".")
+				verrs.FieldError(field, RequestValidationMessage(fe))
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	if v, ok := req.(RequestValidator); ok {
+		if ve := v.Validate(); ve != nil {
+			verrs.Merge(ve)
+		}
+	}
+	if verrs.HaveErrors() {
+		return &requestValidationError{verrs: &verrs}
+	}
+	return nil
+}
+
+// RequestValidationFailedHandler builds the response of the invalid requests of the compo instead of DefaultRequestValidationFailedResponse.
+type RequestValidationFailedHandler interface {
+	OnRequestValidationFailed(ctx context.Context, method string, verrs *web.ValidationErrors) (web.EventResponse, error)
+}
+
+type requestValidationErrorsCtxKey struct{}
+
+// RequestValidationErrors returns the errors of the invalid request when the compo is reloaded by DefaultRequestValidationFailedResponse,
+// it returns nil if there is no error.
+func RequestValidationErrors(ctx context.Context) *web.ValidationErrors {
+	verrs, _ := ctx.Value(requestValidationErrorsCtxKey{}).(*web.ValidationErrors)
+	return verrs
+}
+
+// DefaultRequestValidationFailedResponse reloads the compo with the errors in the context, which are returned by RequestValidationErrors,
+// and responds the field errors as the data.
+func DefaultRequestValidationFailedResponse(c h.HTMLComponent, verrs *web.ValidationErrors) (r web.EventResponse) {
+	r.Data = verrs.FieldErrors()
+	ident, ok := c.(Identifiable)
+	if !ok {
+		return
+	}
+	AppendReloadToResponse(&r, ident)
+	for _, up := range r.UpdatePortals {
+		body := up.Body
+		up.Body = h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+			return body.MarshalHTML(context.WithValue(ctx, requestValidationErrorsCtxKey{}, verrs))
+		})
+	}
+	return
+}
+
+func onRequestValidationFailed(ctx context.Context, c h.HTMLComponent, method string, verrs *web.ValidationErrors) (web.EventResponse, error) {
+	if handler, ok := c.(RequestValidationFailedHandler); ok {
+		return handler.OnRequestValidationFailed(ctx, method, verrs)
+	}
+	return DefaultRequestValidationFailedResponse(c, verrs), nil
+}
@@ -0,0 +1,106 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type requestValidationTestForm struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+func (c *requestValidationTestForm) CompoID() string {
+	return fmt.Sprintf("requestValidationTestForm:%s", c.ID)
+}
+
+func (c *requestValidationTestForm) MarshalHTML(ctx context.Context) ([]byte, error) {
+	var errs h.HTMLComponents
+	if verrs := RequestValidationErrors(ctx); verrs != nil {
+		for _, msg := range verrs.GetFieldErrors("title") {
+			errs = append(errs, h.Span(msg).Class("error"))
+		}
+	}
+	return Actionable(ctx, c, h.Text(c.Title), errs).MarshalHTML(ctx)
+}
+
+type requestValidationTestItem struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type requestValidationTestRenameRequest struct {
+	Title string                      `json:"title" validate:"required,max=5"`
+	Items []requestValidationTestItem `json:"items" validate:"dive"`
+}
+
+func (req *requestValidationTestRenameRequest) Validate() *web.ValidationErrors {
+	var verrs web.ValidationErrors
+	if req.Title == "admin" {
+		verrs.FieldError("title", "is reserved")
+	}
+	return &verrs
+}
+
+func (c *requestValidationTestForm) Rename(ctx context.Context, req *requestValidationTestRenameRequest) (r web.EventResponse, err error) {
+	c.Title = req.Title
+	AppendReloadToResponse(&r, c)
+	return
+}
+
+func init() {
+	RegisterActionableCompoType((*requestValidationTestForm)(nil))
+}
+
+func TestRequestValidation(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &requestValidationTestForm{ID: "0"}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	post := func(request string) *multipartestutils.TestEventResponse {
+		req := multipartestutils.NewMultipartBuilder().
+			PageURL("/").
+			EventFunc(EventDispatchAction).
+			AddField(FieldKeyAction, PrettyJSONString(Action{
+				CompoType: "*stateful.requestValidationTestForm",
+				Compo:     []byte(`{"id":"0","title":"old"}`),
+				Method:    "Rename",
+				Request:   []byte(request),
+			})).
+			BuildEventFuncRequest()
+		w := httptest.NewRecorder()
+		pb.ServeHTTP(w, req)
+		var r multipartestutils.TestEventResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+		return &r
+	}
+
+	r := post(`{"title":"new"}`)
+	assert.Nil(t, r.Data)
+	require.Len(t, r.UpdatePortals, 1)
+	assert.Contains(t, r.UpdatePortals[0].Body, "new")
+
+	r = post(`{"title":"too long","items":[{"name":"a"},{"name":""}]}`)
+	assert.Equal(t, map[string]any{
+		"title":         []any{"failed on the max=5 validation"},
+		"items[1].name": []any{"failed on the required validation"},
+	}, r.Data)
+	require.Len(t, r.UpdatePortals, 1)
+	// the method is not called, the compo is reloaded with the errors
+	assert.Contains(t, r.UpdatePortals[0].Body, "old")
+	assert.Contains(t, r.UpdatePortals[0].Body, `<span class='error'>failed on the max=5 validation</span>`)
+
+	r = post(`{"title":"admin"}`)
+	assert.Equal(t, map[string]any{"title": []any{"is reserved"}}, r.Data)
+	assert.Contains(t, r.UpdatePortals[0].Body, `<span class='error'>is reserved</span>`)
+}
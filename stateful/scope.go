@@ -0,0 +1,228 @@
+package stateful
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/qor5/web/v3"
+	"github.com/samber/lo"
+	"github.com/theplant/inject"
+)
+
+var ErrRequestScopeNotFound = errors.New("request scope not found")
+
+// RequestScope holds the child injectors created for one request.
+// Scoped providers can depend on *RequestScope to register cleanups, e.g. to rollback a transaction.
+type RequestScope struct {
+	ctx       context.Context
+	mu        sync.Mutex
+	injectors map[*inject.Injector]*inject.Injector
+	cleanups  []func()
+	closed    bool
+}
+
+type requestScopeCtxKey struct{}
+
+func WithRequestScope(ctx context.Context) (context.Context, *RequestScope) {
+	scope := &RequestScope{
+		injectors: map[*inject.Injector]*inject.Injector{},
+	}
+	scope.ctx = context.WithValue(ctx, requestScopeCtxKey{}, scope)
+	return scope.ctx, scope
+}
+
+func RequestScopeFromContext(ctx context.Context) (*RequestScope, bool) {
+	scope, ok := ctx.Value(requestScopeCtxKey{}).(*RequestScope)
+	return scope, ok
+}
+
+// requestScope returns the scope of the request in ctx.
+// Without RequestScopeMiddleware, it is created on the first use and kept in the request of the EventContext,
+// so the page render, the actions and the reloads of the same request share it,
+// and it is cleaned up after the response is written, see web.AfterResponse.
+func requestScope(ctx context.Context) (*RequestScope, bool) {
+	if scope, ok := RequestScopeFromContext(ctx); ok {
+		return scope, true
+	}
+	evCtx, ok := web.GetEventContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	if scope, ok := RequestScopeFromContext(evCtx.R.Context()); ok {
+		return scope, true
+	}
+	_, scope := WithRequestScope(evCtx.R.Context())
+	if !web.AfterResponse(ctx, scope.Cleanup) {
+		return nil, false
+	}
+	evCtx.WithContextValue(requestScopeCtxKey{}, scope)
+	scope.ctx = evCtx.R.Context()
+	return scope, true
+}
+
+// RequestScopeMiddleware creates a RequestScope for each request and cleans it up after the response is written.
+func RequestScopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, scope := WithRequestScope(r.Context())
+		defer scope.Cleanup()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *RequestScope) OnCleanup(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanups = append(s.cleanups, f)
+}
+
+// Cleanup runs the registered cleanups in reverse order, it is safe to call it multiple times.
+func (s *RequestScope) Cleanup() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	cleanups := s.cleanups
+	s.cleanups = nil
+	s.injectors = nil
+	s.mu.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+func (s *RequestScope) injector(base *inject.Injector, build func() (*inject.Injector, error)) (*inject.Injector, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("request scope already cleaned up")
+	}
+	if inj, ok := s.injectors[base]; ok {
+		return inj, nil
+	}
+	inj, err := build()
+	if err != nil {
+		return nil, err
+	}
+	s.injectors[base] = inj
+	return inj, nil
+}
+
+func (dc *DependencyCenter) ProvideScoped(name string, fs ...any) error {
+	if _, err := dc.Injector(name); err != nil {
+		return err
+	}
+	for _, f := range fs {
+		if reflect.TypeOf(f).Kind() != reflect.Func {
+			panic("ProvideScoped only accepts a function")
+		}
+	}
+
+	dc.mu.Lock()
+	dc.scopedProviders[name] = append(dc.scopedProviders[name], fs...)
+	dc.mu.Unlock()
+	for _, f := range fs {
+		dc.recordProvider(name, f, true)
+	}
+	return nil
+}
+
+func (dc *DependencyCenter) MustProvideScoped(name string, fs ...any) {
+	err := dc.ProvideScoped(name, fs...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// collectScopedProviders returns the scoped providers of the injector and its ancestors, the nearest first.
+func (dc *DependencyCenter) collectScopedProviders(name string) []any {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	var fs []any
+	for name != "" {
+		fs = append(fs, dc.scopedProviders[name]...)
+		name = dc.parents[name]
+	}
+	return fs
+}
+
+func (dc *DependencyCenter) hasScopedProviders(name string) bool {
+	return len(dc.collectScopedProviders(name)) > 0
+}
+
+// scopedInjector returns the child injector of the named injector for the request scope in ctx.
+// It returns the long-lived injector if there are no scoped providers.
+func (dc *DependencyCenter) scopedInjector(ctx context.Context, name string) (*inject.Injector, error) {
+	base, err := dc.Injector(name)
+	if err != nil {
+		return nil, err
+	}
+	fs := dc.collectScopedProviders(name)
+	if len(fs) == 0 {
+		return base, nil
+	}
+
+	scope, ok := requestScope(ctx)
+	if !ok {
+		return nil, ErrRequestScopeNotFound
+	}
+	return scope.injector(base, func() (*inject.Injector, error) {
+		inj := inject.New()
+		if err := inj.SetParent(base); err != nil {
+			return nil, err
+		}
+
+		builtins := []any{
+			// the context of the request instead of the one of the compo resolved first
+			func() context.Context { return scope.ctx },
+			func() *RequestScope { return scope },
+		}
+		if evCtx, ok := web.GetEventContext(ctx); ok {
+			builtins = append(builtins,
+				func() *web.EventContext { return evCtx },
+				func() *http.Request { return evCtx.R },
+			)
+		}
+		if err := inj.Provide(builtins...); err != nil {
+			return nil, err
+		}
+
+		provided := map[reflect.Type]bool{}
+		for _, f := range builtins {
+			provided[reflect.TypeOf(f).Out(0)] = true
+		}
+		for _, f := range fs {
+			outTypes := providerOutTypes(f)
+			// the nearer provider shadows the farther one
+			if _, shadowed := lo.Find(outTypes, func(rt reflect.Type) bool { return provided[rt] }); shadowed {
+				continue
+			}
+			if err := inj.Provide(f); err != nil {
+				return nil, err
+			}
+			for _, rt := range outTypes {
+				provided[rt] = true
+			}
+		}
+		return inj, nil
+	})
+}
+
+var typeError = reflect.TypeOf((*error)(nil)).Elem()
+
+func providerOutTypes(f any) []reflect.Type {
+	rt := reflect.TypeOf(f)
+	var outs []reflect.Type
+	for i := 0; i < rt.NumOut(); i++ {
+		if i == rt.NumOut()-1 && rt.Out(i) == typeError {
+			continue
+		}
+		outs = append(outs, rt.Out(i))
+	}
+	return outs
+}
@@ -0,0 +1,158 @@
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type scopeTestUser struct {
+	Name string
+}
+
+type scopeTestDB struct {
+	Name string
+}
+
+type scopeTestCompo struct {
+	db   *scopeTestDB   `inject:""`
+	user *scopeTestUser `inject:""`
+
+	ID string `json:"id"`
+}
+
+func (c *scopeTestCompo) CompoID() string {
+	return fmt.Sprintf("scopeTestCompo:%s", c.ID)
+}
+
+func (c *scopeTestCompo) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, h.Text(fmt.Sprintf("%s:%s", c.db.Name, c.user.Name))).MarshalHTML(ctx)
+}
+
+func (c *scopeTestCompo) Hello(ctx context.Context) (r web.EventResponse, err error) {
+	r.RunScript = fmt.Sprintf("alert(%q)", c.user.Name)
+	return
+}
+
+func (c *scopeTestCompo) Refresh(ctx context.Context) (r web.EventResponse, err error) {
+	r.Reload = true
+	return
+}
+
+func init() {
+	RegisterActionableCompoType((*scopeTestCompo)(nil))
+}
+
+func TestRequestScopedProviders(t *testing.T) {
+	var cleanups int32
+	dc := NewDependencyCenter()
+	dc.RegisterInjector("top")
+	dc.RegisterInjector("sub", WithParent("top"))
+	dc.MustProvide("top", func() *scopeTestDB { return &scopeTestDB{Name: "db"} })
+	dc.MustProvideScoped("top", func(evCtx *web.EventContext, scope *RequestScope) *scopeTestUser {
+		scope.OnCleanup(func() { atomic.AddInt32(&cleanups, 1) })
+		return &scopeTestUser{Name: evCtx.R.Header.Get("X-User")}
+	})
+
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = h.Components(
+			dc.MustInject("sub", &scopeTestCompo{ID: "0"}),
+			dc.MustInject("sub", &scopeTestCompo{ID: "1"}),
+		)
+		return
+	})
+	Install(pb, dc)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+	RequestScopeMiddleware(pb).ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "db:alice")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cleanups))
+
+	// without the middleware, the scope is created on the first use and cleaned up after the response is written,
+	// even if the request context is never done
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		pb.ServeHTTP(w, req)
+		return w
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", "alice")
+	w = serve(req)
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "db:alice"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cleanups))
+
+	action := func(method string) *http.Request {
+		req := multipartestutils.NewMultipartBuilder().
+			PageURL("/").
+			EventFunc(EventDispatchAction).
+			AddField(FieldKeyAction, PrettyJSONString(Action{
+				CompoType: "*stateful.scopeTestCompo",
+				Compo:     []byte(`{"id":"0"}`),
+				Injector:  "sub",
+				Method:    method,
+				Request:   []byte(`{}`),
+			})).
+			BuildEventFuncRequest()
+		req.Header.Set("X-User", "bob")
+		return req
+	}
+
+	w = serve(action("Hello"))
+	assert.Contains(t, w.Body.String(), `alert(\"bob\")`)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&cleanups))
+
+	// the page of the reload is rendered in the same scope of the action, before it is cleaned up
+	w = serve(action("Refresh"))
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "db:bob"))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&cleanups))
+}
+
+func TestRequestScopeShadowing(t *testing.T) {
+	dc := NewDependencyCenter()
+	dc.RegisterInjector("top")
+	dc.RegisterInjector("sub", WithParent("top"))
+	dc.MustProvideScoped("top", func() *scopeTestUser { return &scopeTestUser{Name: "top"} })
+	dc.MustProvideScoped("sub", func() *scopeTestUser { return &scopeTestUser{Name: "sub"} })
+	dc.MustProvide("top", func() *scopeTestDB { return &scopeTestDB{Name: "db"} })
+
+	ctx, scope := WithRequestScope(context.Background())
+	defer scope.Cleanup()
+
+	type compoKey struct{}
+	inj, err := dc.scopedInjector(context.WithValue(ctx, compoKey{}, "first"), "sub")
+	require.NoError(t, err)
+	var user *scopeTestUser
+	require.NoError(t, inj.Resolve(&user))
+	assert.Equal(t, "sub", user.Name)
+
+	// the context of the request instead of the one of the compo resolved first
+	var provided context.Context
+	require.NoError(t, inj.Resolve(&provided))
+	assert.Equal(t, ctx, provided)
+	assert.Nil(t, provided.Value(compoKey{}))
+
+	again, err := dc.scopedInjector(ctx, "sub")
+	require.NoError(t, err)
+	assert.Same(t, inj, again)
+
+	inj, err = dc.scopedInjector(ctx, "top")
+	require.NoError(t, err)
+	require.NoError(t, inj.Resolve(&user))
+	assert.Equal(t, "top", user.Name)
+
+	_, err = dc.scopedInjector(context.Background(), "top")
+	assert.ErrorIs(t, err, ErrRequestScopeNotFound)
+}
@@ -2,8 +2,8 @@ package stateful
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
 
 	"github.com/qor5/web/v3"
 	h "github.com/theplant/htmlgo"
@@ -11,15 +11,50 @@ import (
 
 type syncQueryCtxKey struct{}
 
-func withSyncQuery(ctx context.Context) context.Context {
-	return context.WithValue(ctx, syncQueryCtxKey{}, struct{}{})
+// syncQuery is the compo rendered by SyncQuery
+type syncQuery struct {
+	compoType string
+	// defaults are the initial values of the fields which differ from their default tags, see Action.QueryDefaults
+	defaults map[string]string
+}
+
+func withSyncQuery(ctx context.Context, compoType string, defaults map[string]string) context.Context {
+	return context.WithValue(ctx, syncQueryCtxKey{}, &syncQuery{compoType: compoType, defaults: defaults})
 }
 
 func IsSyncQuery(ctx context.Context) bool {
-	_, ok := ctx.Value(syncQueryCtxKey{}).(struct{})
+	_, ok := ctx.Value(syncQueryCtxKey{}).(*syncQuery)
 	return ok
 }
 
+// syncQueryDefaults returns the initial values of the compo of the type rendered by SyncQuery which differ from the default tags
+func syncQueryDefaults(ctx context.Context, compoType string) map[string]string {
+	sq, ok := ctx.Value(syncQueryCtxKey{}).(*syncQuery)
+	if !ok || sq.compoType != compoType {
+		return nil
+	}
+	return sq.defaults
+}
+
+type queryValidationErrorsCtxKey struct{}
+
+// QueryValidationErrors returns the errors of the invalid query values which are clamped or reset
+// when SyncQuery or ParseQuery decodes the url, it returns nil if there is no error.
+func QueryValidationErrors(ctx context.Context) *web.ValidationErrors {
+	verrs, _ := ctx.Value(queryValidationErrorsCtxKey{}).(*web.ValidationErrors)
+	return verrs
+}
+
+// mergeValidationErrors merges err to verrs if it is a validation error, otherwise returns it
+func mergeValidationErrors(verrs *web.ValidationErrors, err error) error {
+	var ve *web.ValidationErrors
+	if errors.As(err, &ve) {
+		verrs.Merge(ve)
+		return nil
+	}
+	return err
+}
+
 func IdentifiableCookieKey(v Identifiable) string {
 	hash := MurmurHash3(fmt.Sprintf("%T:%s", v, v.CompoID()))
 	return fmt.Sprintf("__sync_cookie_%s__", hash)
@@ -38,24 +73,46 @@ func (s *querySyncer) MarshalHTML(ctx context.Context) ([]byte, error) {
 		return nil, err
 	}
 
+	if err := tags.SetDefaults(s.HTMLComponent); err != nil {
+		return nil, err
+	}
+	// the browser leaves the fields equal to their values before decoding out of the url, the caller may have set them
+	defaults, err := tags.initialQueries(s.HTMLComponent)
+	if err != nil {
+		return nil, err
+	}
+
+	var verrs web.ValidationErrors
 	ident, ok := s.HTMLComponent.(Identifiable)
 	if ok {
-		cookie, err := evCtx.R.Cookie(IdentifiableCookieKey(ident))
-		if err != nil && err != http.ErrNoCookie {
-			return nil, err
-		}
-		if cookie != nil {
-			if err := tags.CookieTags().Decode(cookie.Value, s.HTMLComponent); err != nil {
+		for _, name := range tags.StoreNames() {
+			store, ok := LookupQueryStore(name)
+			if !ok {
+				return nil, fmt.Errorf("query store %q not registered", name)
+			}
+			rawQuery, err := store.Load(evCtx, IdentifiableCookieKey(ident))
+			if err != nil {
+				return nil, fmt.Errorf("failed to load query from store %q: %w", name, err)
+			}
+			if rawQuery == "" {
+				continue
+			}
+			err = tags.StoreTags(name).Decode(rawQuery, s.HTMLComponent)
+			if err := mergeValidationErrors(&verrs, err); err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	if err := tags.Decode(evCtx.R.URL.RawQuery, s.HTMLComponent); err != nil {
+	err = tags.Decode(evCtx.R.URL.RawQuery, s.HTMLComponent)
+	if err := mergeValidationErrors(&verrs, err); err != nil {
 		return nil, err
 	}
+	if verrs.HaveErrors() {
+		ctx = context.WithValue(ctx, queryValidationErrorsCtxKey{}, &verrs)
+	}
 	if !s.onlyParse {
-		ctx = withSyncQuery(ctx)
+		ctx = withSyncQuery(ctx, fmt.Sprintf("%T", s.HTMLComponent), defaults)
 	}
 	return s.HTMLComponent.MarshalHTML(ctx)
 }
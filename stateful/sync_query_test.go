@@ -0,0 +1,51 @@
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncQueryTestCompo struct {
+	PageSize int    `json:"page_size" query:"page_size" default:"20" max:"100"`
+	OrderBy  string `json:"order_by" query:"order_by" default:"name" oneof:"name age"`
+}
+
+func (c *syncQueryTestCompo) MarshalHTML(ctx context.Context) ([]byte, error) {
+	var fieldErrors map[string][]string
+	if verrs := QueryValidationErrors(ctx); verrs != nil {
+		fieldErrors = verrs.FieldErrors()
+	}
+	return []byte(fmt.Sprintf("%d:%s:%v", c.PageSize, c.OrderBy, fieldErrors)), nil
+}
+
+func TestSyncQueryDefaultsAndValidation(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = SyncQuery(&syncQueryTestCompo{PageSize: 50})
+		return
+	})
+
+	w := httptest.NewRecorder()
+	web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = SyncQuery(&syncQueryTestCompo{})
+		return
+	}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, w.Body.String(), "20:name:map[]")
+
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, w.Body.String(), "50:name:map[]")
+
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?page_size=30", nil))
+	assert.Contains(t, w.Body.String(), "30:name:map[]")
+
+	w = httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?page_size=500&order_by=email", nil))
+	assert.Contains(t, w.Body.String(), `100:name:map[order_by:[invalid value "email", expected one of [name age]] page_size:[500 is greater than the maximum 100]]`)
+}
@@ -0,0 +1,88 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/qor5/web/v3/stateful"
+	"github.com/qor5/web/v3/stateful/internal/harness"
+	h "github.com/theplant/htmlgo"
+)
+
+// renderedCompo is a compo rendered by stateful.Actionable
+type renderedCompo struct {
+	// PortalName is the name of the portal the Identifiable compo is rendered in, it is empty for the others
+	PortalName string
+	// Action is the action base the compo posts, with the state and the version it is rendered with
+	Action stateful.Action
+}
+
+// parseRenderedCompos returns the compos rendered by stateful.Actionable in the html in document order
+func parseRenderedCompos(body string) ([]*renderedCompo, error) {
+	rcs, err := harness.ParseRenderedCompos(body)
+	if err != nil {
+		return nil, err
+	}
+	compos := make([]*renderedCompo, 0, len(rcs))
+	for _, rc := range rcs {
+		c := &renderedCompo{PortalName: rc.PortalName}
+		if err := json.Unmarshal(rc.Action, &c.Action); err != nil {
+			return nil, err
+		}
+		compos = append(compos, c)
+	}
+	return compos, nil
+}
+
+// Compo is a compo rendered by stateful.Actionable, its actions can be called without a browser.
+type Compo[T h.HTMLComponent] struct {
+	// State is the state of the compo, it is posted with the actions, so it can be changed before Call.
+	State T
+
+	page     *Page
+	rendered *renderedCompo
+}
+
+func newCompo[T h.HTMLComponent](page *Page, rc *renderedCompo) (*Compo[T], error) {
+	state, err := decodeState[T](rc.Action.Compo)
+	if err != nil {
+		return nil, err
+	}
+	return &Compo[T]{State: state, page: page, rendered: rc}, nil
+}
+
+func decodeState[T h.HTMLComponent](data []byte) (T, error) {
+	var zero T
+	rt := reflect.TypeOf((*T)(nil)).Elem()
+	if rt.Kind() != reflect.Ptr {
+		return zero, fmt.Errorf("compo type %v must be a pointer", rt)
+	}
+	v := reflect.New(rt.Elem()).Interface().(T)
+	if err := json.Unmarshal(data, v); err != nil {
+		return zero, fmt.Errorf("failed to decode state of %T: %w", v, err)
+	}
+	return v, nil
+}
+
+func compoTypeName[T h.HTMLComponent]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
+
+// findCompo returns the first compo of type T, id is matched to the portal name of the Identifiable compos if not empty
+func findCompo[T h.HTMLComponent](compos []*renderedCompo, id string) (*renderedCompo, error) {
+	typeName := compoTypeName[T]()
+	for _, c := range compos {
+		if c.Action.CompoType != typeName {
+			continue
+		}
+		if id != "" && c.PortalName != id {
+			continue
+		}
+		return c, nil
+	}
+	if id != "" {
+		return nil, fmt.Errorf("compo %s with id %q not found", typeName, id)
+	}
+	return nil, fmt.Errorf("compo %s not found", typeName)
+}
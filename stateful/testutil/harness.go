@@ -0,0 +1,266 @@
+// Package testutil tests the stateful compos without a browser.
+//
+//	h := testutil.New(pageBuilder)
+//	page, err := h.Get("/todos?page=2")
+//	list, err := testutil.Find[*TodoList](page, "todos")
+//	result, err := list.Call("Toggle", ToggleRequest{ID: "1"})
+//	// result.Response is the event response, result.Compo.State is the state after the action
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/qor5/web/v3/stateful"
+	"github.com/qor5/web/v3/stateful/internal/harness"
+	h "github.com/theplant/htmlgo"
+)
+
+// Harness renders the pages and calls the actions of the compos against the handler like a browser tab,
+// it keeps the current url, which is updated by the actions of the compos with SyncQuery, and the cookies.
+type Harness struct {
+	handler http.Handler
+	jar     http.CookieJar
+	url     *url.URL
+}
+
+func New(handler http.Handler) *Harness {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(err)
+	}
+	u, _ := url.Parse("http://example.com/")
+	return &Harness{handler: handler, jar: jar, url: u}
+}
+
+// URL returns the current url of the page
+func (b *Harness) URL() string {
+	return b.url.RequestURI()
+}
+
+// Cookies returns the cookies kept for the current url
+func (b *Harness) Cookies() []*http.Cookie {
+	return b.jar.Cookies(b.url)
+}
+
+// SetCookies sets the cookies like they were set by the previous responses
+func (b *Harness) SetCookies(cookies ...*http.Cookie) {
+	b.jar.SetCookies(b.url, cookies)
+}
+
+func (b *Harness) serve(r *http.Request) (w *httptest.ResponseRecorder, err error) {
+	for _, cookie := range b.jar.Cookies(r.URL) {
+		r.AddCookie(cookie)
+	}
+	// the errors of the event funcs are panicked by web
+	defer func() {
+		if v := recover(); v != nil {
+			if e, ok := v.(error); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+	w = httptest.NewRecorder()
+	b.handler.ServeHTTP(w, r)
+	b.jar.SetCookies(r.URL, w.Result().Cookies())
+	if w.Code >= http.StatusBadRequest {
+		return w, fmt.Errorf("%s %s: status %d: %s", r.Method, r.URL.RequestURI(), w.Code, w.Body.String())
+	}
+	return w, nil
+}
+
+// Page is a rendered page
+type Page struct {
+	Body string
+
+	harness *Harness
+	// compos are the compos mounted in the page, the ones reloaded or patched by the actions are replaced
+	compos []*renderedCompo
+}
+
+// mount replaces the compo with the same id like the browser does with the reloaded portals and the patched locals
+func (p *Page) mount(rc *renderedCompo) {
+	if rc.Action.CompoID == "" {
+		return
+	}
+	for i, c := range p.compos {
+		if c.Action.CompoID == rc.Action.CompoID {
+			p.compos[i] = rc
+			return
+		}
+	}
+	p.compos = append(p.compos, rc)
+}
+
+// liveState returns the state of the compo mounted in the page, it is null if not mounted
+func (p *Page) liveState(compoID string) json.RawMessage {
+	for _, c := range p.compos {
+		if c.Action.CompoID == compoID {
+			return c.Action.Compo
+		}
+	}
+	return json.RawMessage("null")
+}
+
+// Get renders the page of the url and makes it the current url
+func (b *Harness) Get(rawURL string) (*Page, error) {
+	u, err := b.url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	w, err := b.serve(httptest.NewRequest(http.MethodGet, u.String(), nil))
+	if err != nil {
+		return nil, err
+	}
+	compos, err := parseRenderedCompos(w.Body.String())
+	if err != nil {
+		return nil, err
+	}
+	b.url = u
+	return &Page{Body: w.Body.String(), harness: b, compos: compos}, nil
+}
+
+// Find returns the first compo of type T in the page, e.g. Find[*TodoList](page, "").
+// If id is not empty, only the Identifiable compos with the CompoID are matched.
+func Find[T h.HTMLComponent](p *Page, id string) (*Compo[T], error) {
+	rc, err := findCompo[T](p.compos, id)
+	if err != nil {
+		return nil, err
+	}
+	return newCompo[T](p, rc)
+}
+
+// Result is the result of an action
+type Result[T h.HTMLComponent] struct {
+	Response *multipartestutils.TestEventResponse
+	// Compo is the compo with the state reloaded or patched by the response, or the posted state if neither.
+	Compo *Compo[T]
+}
+
+// Call calls the action method of the compo with the request like stateful.PostAction does,
+// method is the name or the method value, e.g. list.Call("Toggle", req) or list.Call((*TodoList).Toggle, req).
+func (c *Compo[T]) Call(method any, request any) (*Result[T], error) {
+	b := c.page.harness
+	methodName, ok := method.(string)
+	if !ok {
+		methodName = stateful.GetFuncName(method)
+	}
+
+	action := c.rendered.Action
+	action.Compo = json.RawMessage(stateful.PrettyJSONString(c.State))
+	action.Method = methodName
+	action.Request = json.RawMessage(stateful.PrettyJSONString(request))
+	// the browser posts the ancestors with their live states
+	action.Ancestors = make([]stateful.Action, len(c.rendered.Action.Ancestors))
+	for i, ancestor := range c.rendered.Action.Ancestors {
+		ancestor.Compo = c.page.liveState(ancestor.CompoID)
+		action.Ancestors[i] = ancestor
+	}
+
+	postURL := &url.URL{Scheme: b.url.Scheme, Host: b.url.Host, Path: b.url.Path}
+	if action.SyncQuery {
+		tags, err := stateful.ParseQueryTags(c.State)
+		if err != nil {
+			return nil, err
+		}
+		if postURL.RawQuery, err = harness.EncodeQuery(c.State, action.Compo, action.QueryDefaults, "", b.url.Query()); err != nil {
+			return nil, err
+		}
+		if action.StoredQueries, err = storedQueries(c.State, action, tags); err != nil {
+			return nil, err
+		}
+	}
+
+	req := multipartestutils.NewMultipartBuilder().
+		PageURL(postURL.String()).
+		EventFunc(stateful.EventDispatchAction).
+		AddField(stateful.FieldKeyAction, stateful.PrettyJSONString(action)).
+		BuildEventFuncRequest()
+	w, err := b.serve(req)
+	if err != nil {
+		return nil, err
+	}
+	if action.SyncQuery {
+		b.url = postURL
+	}
+
+	r := &Result[T]{Response: &multipartestutils.TestEventResponse{}}
+	if err := json.Unmarshal(w.Body.Bytes(), r.Response); err != nil {
+		return nil, fmt.Errorf("failed to decode event response %s: %w", w.Body.String(), err)
+	}
+	if r.Compo, err = c.updated(r.Response, action); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// storedQueries returns the queries saved to the server side stores with the action
+func storedQueries(c any, action stateful.Action, tags stateful.QueryTags) (map[string]string, error) {
+	if _, ok := c.(stateful.Identifiable); !ok {
+		return nil, nil
+	}
+	names := tags.StoreNames()
+	if len(names) == 0 {
+		return nil, nil
+	}
+	queries := map[string]string{}
+	for _, name := range names {
+		store, ok := stateful.LookupQueryStore(name)
+		if !ok {
+			return nil, fmt.Errorf("query store %q not registered", name)
+		}
+		if _, ok := store.(stateful.ClientQueryStore); ok {
+			continue
+		}
+		query, err := harness.EncodeQuery(c, action.Compo, action.QueryDefaults, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		queries[name] = query
+	}
+	return queries, nil
+}
+
+// updated mounts the compos reloaded by the response to the page and returns the compo of the action,
+// which is reloaded, patched, or the posted one if neither.
+func (c *Compo[T]) updated(r *multipartestutils.TestEventResponse, posted stateful.Action) (*Compo[T], error) {
+	var reloaded *renderedCompo
+	for _, up := range r.UpdatePortals {
+		compos, err := parseRenderedCompos(up.Body)
+		if err != nil {
+			return nil, err
+		}
+		for _, rc := range compos {
+			if rc.PortalName == "" && rc.Action.CompoID == up.Name {
+				rc.PortalName = up.Name
+			}
+			c.page.mount(rc)
+		}
+		if c.rendered.PortalName == "" || up.Name != c.rendered.PortalName {
+			continue
+		}
+		if reloaded, err = findCompo[T](compos, ""); err != nil {
+			return nil, fmt.Errorf("portal %q: %w", up.Name, err)
+		}
+		reloaded.PortalName = c.rendered.PortalName
+	}
+	if reloaded != nil {
+		return newCompo[T](c.page, reloaded)
+	}
+
+	rc := &renderedCompo{PortalName: c.rendered.PortalName, Action: c.rendered.Action}
+	var err error
+	rc.Action.Compo, rc.Action.Version, err = harness.ApplyPatchScript(r.RunScript, rc.Action.CompoID, posted.Compo, posted.Version)
+	if err != nil {
+		return nil, err
+	}
+	c.page.mount(rc)
+	return newCompo[T](c.page, rc)
+}
@@ -0,0 +1,142 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/stateful"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type harnessCounter struct {
+	ID       string   `json:"id"`
+	Count    int      `json:"count" query:"count,omitempty"`
+	PageSize int      `json:"page_size" query:"page_size;cookie" default:"10"`
+	Tags     []string `json:"tags" query:"tags,omitempty"`
+}
+
+func (c *harnessCounter) CompoID() string {
+	return fmt.Sprintf("harnessCounter:%s", c.ID)
+}
+
+func (c *harnessCounter) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return stateful.Actionable(ctx, c, h.Span(fmt.Sprint(c.Count))).MarshalHTML(ctx)
+}
+
+type harnessIncrRequest struct {
+	Step int `json:"step"`
+}
+
+func (c *harnessCounter) Incr(ctx context.Context, req harnessIncrRequest) (r web.EventResponse, err error) {
+	c.Count += req.Step
+	stateful.AppendPatchToResponse(ctx, &r, c)
+	return
+}
+
+func (c *harnessCounter) Reset(ctx context.Context) (r web.EventResponse, err error) {
+	c.Count = 0
+	stateful.AppendReloadToResponse(&r, c)
+	return
+}
+
+func (c *harnessCounter) Fail(ctx context.Context) (r web.EventResponse, err error) {
+	return r, errors.New("boom")
+}
+
+func init() {
+	stateful.RegisterActionableCompoType((*harnessCounter)(nil))
+}
+
+func TestHarness(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = h.Div(
+			stateful.SyncQuery(&harnessCounter{ID: "0"}),
+		)
+		return
+	})
+	stateful.Install(pb, stateful.NewDependencyCenter())
+
+	b := New(pb)
+	page, err := b.Get("/?count=3&tags=a,b")
+	require.NoError(t, err)
+	counter, err := Find[*harnessCounter](page, "harnessCounter:0")
+	require.NoError(t, err)
+	assert.Equal(t, &harnessCounter{ID: "0", Count: 3, PageSize: 10, Tags: []string{"a", "b"}}, counter.State)
+
+	_, err = Find[*harnessCounter](page, "harnessCounter:1")
+	assert.ErrorContains(t, err, `compo *testutil.harnessCounter with id "harnessCounter:1" not found`)
+
+	// patched
+	counter.State.PageSize = 20
+	result, err := counter.Call("Incr", harnessIncrRequest{Step: 2})
+	require.NoError(t, err)
+	assert.Contains(t, result.Response.RunScript, "applyJsonPatch")
+	assert.Equal(t, 5, result.Compo.State.Count)
+	assert.Equal(t, 20, result.Compo.State.PageSize)
+	// the url is pushed with the state posted
+	assert.Equal(t, "/?count=3&page_size=20&tags=a,b", b.URL())
+	require.Len(t, b.Cookies(), 1)
+	assert.Equal(t, stateful.IdentifiableCookieKey(counter.State), b.Cookies()[0].Name)
+
+	// the sticky page size is restored from the cookie
+	page, err = b.Get("/")
+	require.NoError(t, err)
+	counter, err = Find[*harnessCounter](page, "")
+	require.NoError(t, err)
+	assert.Equal(t, &harnessCounter{ID: "0", PageSize: 20}, counter.State)
+
+	// reloaded
+	counter.State.Count = 7
+	result, err = counter.Call((*harnessCounter).Reset, nil)
+	require.NoError(t, err)
+	require.Len(t, result.Response.UpdatePortals, 1)
+	assert.Equal(t, &harnessCounter{ID: "0", PageSize: 20}, result.Compo.State)
+	assert.Equal(t, "/?count=7&page_size=20", b.URL())
+
+	result, err = result.Compo.Call("Fail", nil)
+	assert.Nil(t, result)
+	assert.ErrorContains(t, err, `failed to call action method "Fail": boom`)
+}
+
+func TestHarnessQueryDefaultsSetByCaller(t *testing.T) {
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = stateful.SyncQuery(&harnessCounter{ID: "0", PageSize: 50})
+		return
+	})
+	stateful.Install(pb, stateful.NewDependencyCenter())
+
+	b := New(pb)
+	page, err := b.Get("/")
+	require.NoError(t, err)
+	counter, err := Find[*harnessCounter](page, "")
+	require.NoError(t, err)
+	assert.Equal(t, 50, counter.State.PageSize)
+
+	// the default of the tag is kept in the url, since the page size is the one set by the caller if absent
+	counter.State.PageSize = 10
+	_, err = counter.Call("Incr", harnessIncrRequest{Step: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "/?page_size=10", b.URL())
+
+	b.SetCookies(&http.Cookie{Name: stateful.IdentifiableCookieKey(counter.State), MaxAge: -1})
+	page, err = b.Get(b.URL())
+	require.NoError(t, err)
+	counter, err = Find[*harnessCounter](page, "")
+	require.NoError(t, err)
+	assert.Equal(t, 10, counter.State.PageSize)
+
+	// the one set by the caller is left out, the action reloaded by the browser keeps it
+	counter.State.PageSize = 50
+	result, err := counter.Call((*harnessCounter).Reset, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/", b.URL())
+	_, err = result.Compo.Call("Incr", harnessIncrRequest{Step: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "/", b.URL())
+}
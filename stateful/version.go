@@ -0,0 +1,170 @@
+package stateful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/qor5/web/v3"
+	"github.com/samber/lo"
+	h "github.com/theplant/htmlgo"
+)
+
+// Versioned compos are checked for the optimistic concurrency, so that the stale actions of the other tabs or users
+// are rejected instead of overwriting the newer changes silently.
+// The version is kept in the locals when the compo is rendered, and compared with the current one when the action is dispatched,
+// it is advanced in the browser by the responses of the actions which change it, see ActionVersion for the conditional writes.
+// The data behind the version is expected to be loaded when rendering, e.g. in BeforeRender, instead of being a part of the state,
+// so that the reload of a conflicted compo renders the current data and version.
+type Versioned interface {
+	Identifiable
+	// CompoVersion returns the version of the data the compo is rendered with, e.g. the revision or the updated_at of a record.
+	CompoVersion() string
+	// CurrentVersion returns the version of the data in the store, it is called after the compo is restored from the action.
+	CurrentVersion(ctx context.Context) (string, error)
+}
+
+// VersionConflictHandler builds the response of the conflicted actions of the compo instead of DefaultVersionConflictResponse.
+type VersionConflictHandler interface {
+	OnVersionConflict(ctx context.Context, err *VersionConflictError) (web.EventResponse, error)
+}
+
+var ErrVersionConflict = errors.New("version conflict")
+
+type VersionConflictError struct {
+	CompoID string
+	Method  string
+	Version string // the version the action is posted with
+	Current string
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("action method %q of compo %q is posted with version %q, but the current version is %q", e.Method, e.CompoID, e.Version, e.Current)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// VersionConflictMessage is alerted by DefaultVersionConflictResponse
+var VersionConflictMessage = "The data has been changed by someone else and is reloaded now, please check it and try again."
+
+// DefaultVersionConflictResponse reloads the compo with the current data and alerts VersionConflictMessage.
+func DefaultVersionConflictResponse(c Identifiable) (r web.EventResponse) {
+	AppendReloadToResponse(&r, c)
+	r.RunScript = fmt.Sprintf("alert(%s)", h.JSONString(VersionConflictMessage))
+	return
+}
+
+// compoVersion returns the version embedded in the action base
+func compoVersion(c any) string {
+	if v, ok := c.(Versioned); ok {
+		return v.CompoVersion()
+	}
+	return ""
+}
+
+type actionVersionCtxKey struct{}
+
+// withActionVersion sets the version the action of the Versioned compo is posted with
+func withActionVersion(ctx context.Context, c h.HTMLComponent, action *Action) context.Context {
+	if _, ok := c.(Versioned); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, actionVersionCtxKey{}, action.Version)
+}
+
+// ActionVersion returns the version the action of the Versioned compo is posted with.
+// The check before the action method can not prevent the changes made after it, so the stores should write conditionally,
+// e.g. `UPDATE ... WHERE version = ?`, and return an error wrapping ErrVersionConflict if nothing is written,
+// which is responded like the conflicts found by the check.
+func ActionVersion(ctx context.Context) (string, bool) {
+	version, ok := ctx.Value(actionVersionCtxKey{}).(string)
+	return version, ok
+}
+
+// checkActionVersion returns the response of the conflict if the compo is changed since the action base was rendered
+func checkActionVersion(ctx context.Context, c h.HTMLComponent, action *Action) (r *web.EventResponse, err error) {
+	v, ok := c.(Versioned)
+	// the reload is how the conflicts are resolved, so it is never rejected
+	if !ok || action.Method == actionMethodReload {
+		return nil, nil
+	}
+	current, err := v.CurrentVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the current version of compo %T: %w", c, err)
+	}
+	if current == action.Version {
+		return nil, nil
+	}
+
+	cr, err := versionConflictResponse(ctx, v, &VersionConflictError{
+		CompoID: v.CompoID(),
+		Method:  action.Method,
+		Version: action.Version,
+		Current: current,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+// onActionVersionConflict responds the ErrVersionConflict returned by the action method like the conflicts found by checkActionVersion,
+// it returns false if the compo is not Versioned or err is not a conflict.
+func onActionVersionConflict(ctx context.Context, c h.HTMLComponent, action *Action, err error) (r web.EventResponse, ok bool, rerr error) {
+	v, versioned := c.(Versioned)
+	if !versioned || !errors.Is(err, ErrVersionConflict) {
+		return r, false, nil
+	}
+	var verr *VersionConflictError
+	if !errors.As(err, &verr) {
+		current, err := v.CurrentVersion(ctx)
+		if err != nil {
+			return r, true, fmt.Errorf("failed to get the current version of compo %T: %w", c, err)
+		}
+		verr = &VersionConflictError{
+			CompoID: v.CompoID(),
+			Method:  action.Method,
+			Version: action.Version,
+			Current: current,
+		}
+	}
+	r, rerr = versionConflictResponse(ctx, v, verr)
+	return r, true, rerr
+}
+
+func versionConflictResponse(ctx context.Context, v Versioned, verr *VersionConflictError) (web.EventResponse, error) {
+	if handler, ok := v.(VersionConflictHandler); ok {
+		return handler.OnVersionConflict(ctx, verr)
+	}
+	return DefaultVersionConflictResponse(v), nil
+}
+
+// appendVersionToResponse advances the version of the compo in the browser if the action changed it,
+// so its next action is not rejected as a conflict, the reloaded and patched compos have the version already.
+func appendVersionToResponse(ctx context.Context, r *web.EventResponse, c h.HTMLComponent, action *Action) error {
+	v, ok := c.(Versioned)
+	if !ok {
+		return nil
+	}
+	suffix := patchScriptSuffix(v.CompoID())
+	if strings.Contains(r.RunScript, suffix) || lo.ContainsBy(r.UpdatePortals, func(up *web.PortalUpdate) bool {
+		return up.Name == v.CompoID()
+	}) {
+		return nil
+	}
+	current, err := v.CurrentVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the current version of compo %T: %w", c, err)
+	}
+	if current == action.Version {
+		return nil
+	}
+	if r.RunScript != "" {
+		r.RunScript += ";\n"
+	}
+	r.RunScript += patchScriptPrefix + patchScriptVersion + h.JSONString(current) + ";" + suffix
+	return nil
+}
@@ -0,0 +1,196 @@
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/web/v3/multipartestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	h "github.com/theplant/htmlgo"
+)
+
+type versionTestRecord struct {
+	title   string
+	version int
+}
+
+var versionTestRecords = map[string]*versionTestRecord{}
+
+type versionTestDoc struct {
+	ID string `json:"id"`
+
+	record versionTestRecord
+}
+
+func (c *versionTestDoc) CompoID() string {
+	return fmt.Sprintf("versionTestDoc:%s", c.ID)
+}
+
+func (c *versionTestDoc) BeforeRender(ctx context.Context) error {
+	c.record = *versionTestRecords[c.ID]
+	return nil
+}
+
+func (c *versionTestDoc) CompoVersion() string {
+	return strconv.Itoa(c.record.version)
+}
+
+func (c *versionTestDoc) CurrentVersion(ctx context.Context) (string, error) {
+	record, ok := versionTestRecords[c.ID]
+	if !ok {
+		return "", errors.New("record not found")
+	}
+	return strconv.Itoa(record.version), nil
+}
+
+func (c *versionTestDoc) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, c.title()).MarshalHTML(ctx)
+}
+
+// title is rendered after BeforeRender loaded the record
+func (c *versionTestDoc) title() h.HTMLComponent {
+	return h.ComponentFunc(func(ctx context.Context) ([]byte, error) {
+		return h.Text(c.record.title).MarshalHTML(ctx)
+	})
+}
+
+type versionTestRenameRequest struct {
+	Title string `json:"title"`
+}
+
+// Touch advances the version without re-rendering the doc
+func (c *versionTestDoc) Touch(ctx context.Context) (r web.EventResponse, err error) {
+	versionTestRecords[c.ID].version++
+	AppendPatchToResponse(ctx, &r, c)
+	return
+}
+
+// Publish changes the version without re-rendering the doc
+func (c *versionTestDoc) Publish(ctx context.Context) (r web.EventResponse, err error) {
+	versionTestRecords[c.ID].version++
+	r.RunScript = "alert('published')"
+	return
+}
+
+// RacyRename is changed by another writer after the version is checked, so the conditional write fails
+func (c *versionTestDoc) RacyRename(ctx context.Context, req versionTestRenameRequest) (r web.EventResponse, err error) {
+	record := versionTestRecords[c.ID]
+	record.version++
+	version, _ := ActionVersion(ctx)
+	if version != strconv.Itoa(record.version) {
+		return r, fmt.Errorf("failed to rename doc %q: %w", c.ID, ErrVersionConflict)
+	}
+	record.title = req.Title
+	return
+}
+
+func (c *versionTestDoc) Rename(ctx context.Context, req versionTestRenameRequest) (r web.EventResponse, err error) {
+	record := versionTestRecords[c.ID]
+	record.title = req.Title
+	record.version++
+	AppendReloadToResponse(&r, c)
+	return
+}
+
+type versionTestCustomDoc struct {
+	versionTestDoc
+}
+
+func (c *versionTestCustomDoc) MarshalHTML(ctx context.Context) ([]byte, error) {
+	return Actionable(ctx, c, c.title()).MarshalHTML(ctx)
+}
+
+func (c *versionTestCustomDoc) OnVersionConflict(ctx context.Context, err *VersionConflictError) (r web.EventResponse, _ error) {
+	r.RunScript = fmt.Sprintf("alert(%q)", err.Error())
+	return
+}
+
+func init() {
+	RegisterActionableCompoType((*versionTestDoc)(nil), (*versionTestCustomDoc)(nil))
+}
+
+func TestVersionConflict(t *testing.T) {
+	versionTestRecords["1"] = &versionTestRecord{title: "draft", version: 1}
+
+	pb := web.Page(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = &versionTestDoc{ID: "1"}
+		return
+	})
+	Install(pb, NewDependencyCenter())
+
+	w := httptest.NewRecorder()
+	pb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, w.Body.String(), `"version":"1"`)
+	assert.Contains(t, w.Body.String(), `v.version = this.version;`)
+
+	post := func(compoType string, method string, version string) *multipartestutils.TestEventResponse {
+		req := multipartestutils.NewMultipartBuilder().
+			PageURL("/").
+			EventFunc(EventDispatchAction).
+			AddField(FieldKeyAction, PrettyJSONString(Action{
+				CompoType: compoType,
+				Compo:     []byte(`{"id":"1"}`),
+				Method:    method,
+				Request:   []byte(`{"title":"final"}`),
+				Version:   version,
+			})).
+			BuildEventFuncRequest()
+		w := httptest.NewRecorder()
+		pb.ServeHTTP(w, req)
+		var r multipartestutils.TestEventResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+		return &r
+	}
+
+	r := post("*stateful.versionTestDoc", "Rename", "1")
+	assert.Empty(t, r.RunScript)
+	require.Len(t, r.UpdatePortals, 1)
+	assert.Contains(t, r.UpdatePortals[0].Body, `"version":"2"`)
+	assert.Equal(t, &versionTestRecord{title: "final", version: 2}, versionTestRecords["1"])
+
+	// the other tab posts with the stale version
+	r = post("*stateful.versionTestDoc", "Rename", "1")
+	assert.Equal(t, fmt.Sprintf("alert(%s)", h.JSONString(VersionConflictMessage)), r.RunScript)
+	require.Len(t, r.UpdatePortals, 1)
+	assert.Equal(t, "versionTestDoc:1", r.UpdatePortals[0].Name)
+	assert.Contains(t, r.UpdatePortals[0].Body, `"version":"2"`)
+	assert.Contains(t, r.UpdatePortals[0].Body, "final")
+	assert.Equal(t, 2, versionTestRecords["1"].version)
+
+	// the reload is never rejected
+	r = post("*stateful.versionTestDoc", actionMethodReload, "1")
+	assert.Empty(t, r.RunScript)
+	require.Len(t, r.UpdatePortals, 1)
+
+	// the patch advances the version in the browser
+	r = post("*stateful.versionTestDoc", "Touch", "2")
+	assert.Equal(t, `((locals) => { if (locals) { locals.version = "3"; } })((vars.__statefulCompos || {})["versionTestDoc:1"])`, r.RunScript)
+	assert.Empty(t, r.UpdatePortals)
+
+	// the version changed by the action is advanced in the browser without a reload
+	r = post("*stateful.versionTestDoc", "Publish", "3")
+	assert.Equal(t, "alert('published');\n"+`((locals) => { if (locals) { locals.version = "4"; } })((vars.__statefulCompos || {})["versionTestDoc:1"])`, r.RunScript)
+	assert.Empty(t, r.UpdatePortals)
+
+	// the conflict of the conditional write is responded like the checked one
+	r = post("*stateful.versionTestDoc", "RacyRename", "4")
+	assert.Equal(t, fmt.Sprintf("alert(%s)", h.JSONString(VersionConflictMessage)), r.RunScript)
+	require.Len(t, r.UpdatePortals, 1)
+	assert.Contains(t, r.UpdatePortals[0].Body, `"version":"5"`)
+	assert.Equal(t, &versionTestRecord{title: "final", version: 5}, versionTestRecords["1"])
+
+	r = post("*stateful.versionTestCustomDoc", "Rename", "1")
+	assert.Equal(t, `alert("action method \"Rename\" of compo \"versionTestDoc:1\" is posted with version \"1\", but the current version is \"5\"")`, r.RunScript)
+	assert.Empty(t, r.UpdatePortals)
+
+	assert.ErrorIs(t, &VersionConflictError{}, ErrVersionConflict)
+	_, ok := ActionVersion(context.Background())
+	assert.False(t, ok)
+}
@@ -263,6 +263,21 @@ func (b *VueEventTagBuilder) ThenScript(script string) (r *VueEventTagBuilder) {
 	return b
 }
 
+// BeforeScriptJS is BeforeScript of the script built by JS
+func (b *VueEventTagBuilder) BeforeScriptJS(v Var) (r *VueEventTagBuilder) {
+	return b.BeforeScript(string(v))
+}
+
+// AfterScriptJS is AfterScript of the script built by JS
+func (b *VueEventTagBuilder) AfterScriptJS(v Var) (r *VueEventTagBuilder) {
+	return b.AfterScript(string(v))
+}
+
+// ThenScriptJS is ThenScript of the script built by JS, the response is the variable r
+func (b *VueEventTagBuilder) ThenScriptJS(v Var) (r *VueEventTagBuilder) {
+	return b.ThenScript(string(v))
+}
+
 func (b *VueEventTagBuilder) String() string {
 	var cs []string
 	for _, c := range b.calls {
@@ -317,13 +332,9 @@ func (b *VueEventTagBuilder) MarshalJSON() ([]byte, error) {
 }
 
 func VAssign(varName string, v interface{}) []interface{} {
-	varVal, ok := v.(string)
-	if !ok {
-		varVal = h.JSONString(v)
-	}
 	return []interface{}{
 		"v-assign",
-		fmt.Sprintf("[%s, %s]", varName, varVal),
+		fmt.Sprintf("[%s, %s]", varName, jsScript(v)),
 	}
 }
 
@@ -343,6 +354,11 @@ func RunScript(s string) *h.HTMLTagBuilder {
 	return h.Div().Style("display: none;").Attr("v-on-mounted", s)
 }
 
+// RunScriptJS is RunScript of the script built by JS
+func RunScriptJS(v Var) *h.HTMLTagBuilder {
+	return RunScript(string(v))
+}
+
 func Emit(name string, payloads ...any) string {
 	args := []string{
 		strconv.Quote(strcase.ToCamel(name)),
@@ -369,6 +385,11 @@ func Listen(vs ...string) *h.HTMLTagBuilder {
 	return t
 }
 
+// ListenJS is Listen of the event by the script built by JS
+func ListenJS(event string, v Var) *h.HTMLTagBuilder {
+	return Listen(event, string(v))
+}
+
 func setOnAttr(tag *h.HTMLTagBuilder, event string, fn string) {
 	fn = strings.TrimSpace(fn)
 	if !strings.HasPrefix(fn, "function") && !strings.HasPrefix(fn, "(") {